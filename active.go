@@ -0,0 +1,56 @@
+package ataxi
+
+// ActiveTaxiCount is the number of taxis on the road during a minute of the
+// simulation.
+type ActiveTaxiCount struct {
+	Day      int
+	Min      int // minute of the day
+	NumTaxis int
+}
+
+// ActiveTaxis counts the taxis on the road in every minute of the simulation.
+// A taxi is active from the minute it departs through the minute it drops off
+// its last passenger.
+type ActiveTaxis struct {
+	// deltas holds the change in the number of active taxis for every minute
+	// since the start of the simulation. It grows with the horizon, so trips
+	// that run past midnight are counted on the day they actually end.
+	deltas []int
+}
+
+// Add counts a taxi active between its departure and made empty times. A made
+// empty time before the departure is a time of day that wrapped past
+// midnight, so the taxi is counted until that time on the next day.
+func (a *ActiveTaxis) Add(departure SimTime, madeEmpty SimTime) {
+	start := departure.Minute()
+	end := madeEmpty.Minute()
+	if end < start {
+		end += SecondsPerDay / 60
+	}
+	for len(a.deltas) < end+2 {
+		a.deltas = append(a.deltas, 0)
+	}
+	a.deltas[start]++
+	a.deltas[end+1]--
+}
+
+// AddTaxi counts a taxi active from its departure until it is made empty.
+func (a *ActiveTaxis) AddTaxi(taxi *Taxi) {
+	a.Add(SimTime(taxi.DepartureTime), taxi.MadeEmptyTime())
+}
+
+// Counts returns the number of active taxis in every minute, padded out to
+// whole days so every day has 1440 minutes.
+func (a *ActiveTaxis) Counts() []ActiveTaxiCount {
+	numMinutes := (len(a.deltas) + 1439) / 1440 * 1440
+	counts := make([]ActiveTaxiCount, numMinutes)
+	var sum int
+	for min := range counts {
+		if min < len(a.deltas) {
+			sum += a.deltas[min]
+		}
+		t := SimTime(min * 60)
+		counts[min] = ActiveTaxiCount{Day: t.Day(), Min: t.MinuteOfDay(), NumTaxis: sum}
+	}
+	return counts
+}
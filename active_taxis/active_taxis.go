@@ -10,6 +10,8 @@ import (
 	"os"
 	"strconv"
 	"time"
+
+	"github.com/webapps/ataxi"
 )
 
 func main() {
@@ -31,13 +33,13 @@ func main() {
     defer file.Close()
 
     writer := csv.NewWriter(file)
-	writer.Write([]string{"Min", "NumTaxis"})
+	writer.Write([]string{"Day", "Min", "NumTaxis"})
     defer writer.Flush()
 
 	start := time.Now()
 	fmt.Println("Processing provided ataxi trip file ...")
 
-    var activeTaxis [1440]int
+    var activeTaxis ataxi.ActiveTaxis
     var counter int
 	for {
 		line, err := reader.Read()
@@ -47,19 +49,10 @@ func main() {
 			log.Fatal(err)
 		}
 
-	    departureTime, _ := strconv.ParseInt(line[2], 10, 64)
-        madeEmptyTime, _ := strconv.ParseInt(line[5], 10, 64)
-
-        start := int(departureTime) / 60
-        end := int(madeEmptyTime) / 60
+	    departureTime, _ := strconv.ParseUint(line[2], 10, 32)
+        madeEmptyTime, _ := strconv.ParseUint(line[5], 10, 32)
 
-        activeTaxis[start]++
-        if end < 1439 {
-            activeTaxis[end+1]--
-        }
-        if start > end {
-            activeTaxis[0]++
-        }
+        activeTaxis.Add(ataxi.SimTime(departureTime), ataxi.SimTime(madeEmptyTime))
         counter++
 		if counter%10000 == 0 {
 			fmt.Printf("\rProcessed %d records", counter)
@@ -71,16 +64,11 @@ func main() {
 	elapsed := time.Since(start)
 	fmt.Printf("ataxi trips file processing took %s\n", elapsed)
 
-    var sum int
-    for i := 0; i < len(activeTaxis); i++ {
-        sum += activeTaxis[i]
-        activeTaxis[i] = sum
-    }
-
-    var row [2]string
-    for min, numTaxis := range activeTaxis {
-        row[0] = strconv.Itoa(min)
-        row[1] = strconv.Itoa(numTaxis)
+    var row [3]string
+    for _, count := range activeTaxis.Counts() {
+        row[0] = strconv.Itoa(count.Day)
+        row[1] = strconv.Itoa(count.Min)
+        row[2] = strconv.Itoa(count.NumTaxis)
         writer.Write(row[:])
     }
 
@@ -0,0 +1,63 @@
+package ataxi
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestActiveTaxis(t *testing.T) {
+	at := func(day, hour, min int) SimTime {
+		return SimTime(day*SecondsPerDay + hour*3600 + min*60)
+	}
+	tests := []struct {
+		name      string
+		departure SimTime
+		madeEmpty SimTime
+		days      int
+		// first and last minute the taxi is active, and how many minutes
+		want string
+	}{
+		{"same day", at(0, 8, 0), at(0, 8, 30), 1, "{0 480 1} {0 510 1} 31"},
+		{"past midnight", at(0, 23, 50), at(0, 0, 10), 2, "{0 1430 1} {1 10 1} 21"},
+		{"several days", at(0, 23, 0), at(2, 1, 0), 3, "{0 1380 1} {2 60 1} 1561"},
+		{"instant", at(1, 12, 0), at(1, 12, 0), 2, "{1 720 1} {1 720 1} 1"},
+	}
+	for _, test := range tests {
+		var active ActiveTaxis
+		active.Add(test.departure, test.madeEmpty)
+		counts := active.Counts()
+		if len(counts) != test.days*1440 {
+			t.Errorf("%s: got %d minutes, want %d", test.name, len(counts), test.days*1440)
+			continue
+		}
+		var first, last ActiveTaxiCount
+		var minutes int
+		for i, count := range counts {
+			if count.Day != i/1440 || count.Min != i%1440 {
+				t.Errorf("%s: minute %d is %+v", test.name, i, count)
+			}
+			if count.NumTaxis > 0 {
+				if minutes == 0 {
+					first = count
+				}
+				last = count
+				minutes++
+			}
+		}
+		if got := fmt.Sprint(first, " ", last, " ", minutes); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestActiveTaxisOverlap(t *testing.T) {
+	var active ActiveTaxis
+	active.Add(SimTime(10*60), SimTime(20*60))
+	active.Add(SimTime(15*60), SimTime(25*60))
+	counts := active.Counts()
+	got := fmt.Sprint(counts[9].NumTaxis, counts[10].NumTaxis, counts[15].NumTaxis,
+		counts[20].NumTaxis, counts[21].NumTaxis, counts[25].NumTaxis, counts[26].NumTaxis)
+	if got != "0 1 2 2 1 1 0" {
+		t.Errorf("got %s, want 0 1 2 2 1 1 0", got)
+	}
+}
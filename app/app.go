@@ -1,119 +1,606 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/webapps/ataxi"
 )
 
-var (
-	mapTmpl = parseTemplate("map.html")
+// There is no server-wide write timeout: it would cut off csv and ndjson
+// exports, which stream for as long as they take. Each handler is bounded by
+// the deadline appHandler sets on its request's context instead.
+const (
+	readTimeout     = 10 * time.Second
+	idleTimeout     = 2 * time.Minute
+	shutdownTimeout = 30 * time.Second
 )
 
+var mapTmpl *appTemplate
+
 func main() {
+	configPath := flag.String("config", "", "path of the config file (default $"+ataxi.ConfigPathEnv+" or "+ataxi.DefaultConfigPath+")")
+	addr := flag.String("addr", "", "listen address, overrides the config listen_addr")
+	dbDriver := flag.String("db-driver", "", "database driver, overrides the config db_driver")
+	dsn := flag.String("dsn", "", "database DSN, overrides the config dsn")
+	staticDir := flag.String("static", "", "static files directory, overrides the config static_dir")
+	templateDir := flag.String("templates", "", "templates directory, overrides the config template_dir")
+	flag.Parse()
+
+	config, err := ataxi.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	flags := map[*string]*string{
+		addr:        &config.ListenAddr,
+		dbDriver:    &config.DBDriver,
+		dsn:         &config.DSN,
+		staticDir:   &config.StaticDir,
+		templateDir: &config.TemplateDir,
+	}
+	for flagValue, setting := range flags {
+		if *flagValue != "" {
+			*setting = *flagValue
+		}
+	}
+	ataxi.Config = config
+
+	db, err := ataxi.OpenDB(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	schemaVersion, err := db.SchemaVersion(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ataxi.CheckSchemaVersion(schemaVersion); err != nil {
+		log.Fatal(err)
+	}
+	ataxi.DB = ataxi.NewInstrumentedDB(db)
+	cacheInterval, err := config.CacheCheckInterval()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cacheEnabled = cacheInterval > 0
+	if cacheEnabled {
+		ataxi.DB = ataxi.NewCachedDB(ataxi.DB, cacheInterval)
+	}
+	requestTimeout, err = config.RequestTimeout()
+	if err != nil {
+		log.Fatal(err)
+	}
+	streamTimeout, err = config.StreamRequestTimeout()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mapTmpl = parseTemplate(config.TemplateDir, "map.html")
+	playbackTmpl = parseTemplate(config.TemplateDir, "playback.html")
+	dashboardTmpl = parseTemplate(config.TemplateDir, "dashboard.html")
+	scenariosTmpl = parseTemplate(config.TemplateDir, "scenarios.html")
+
+	r := newRouter(config.StaticDir)
+	if err := checkAPI(r); err != nil {
+		log.Fatal(err)
+	}
+
+	srv := &http.Server{
+		Addr:        config.ListenAddr,
+		Handler:     handlers.CombinedLoggingHandler(os.Stderr, r),
+		ReadTimeout: readTimeout,
+		IdleTimeout: idleTimeout,
+	}
+	done := make(chan struct{})
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-stop
+		log.Printf("Received %s, shutting down...", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown did not complete: %v", err)
+		}
+		close(done)
+	}()
+
+	fmt.Printf("Listening at %s...\n", config.ListenAddr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		ataxi.DB.Close()
+		log.Fatal(err)
+	}
+	<-done
+	ataxi.DB.Close()
+	log.Println("Server stopped")
+}
+
+// newRouter returns the routes of the server, serving static files from
+// staticDir.
+func newRouter(staticDir string) *mux.Router {
 	r := mux.NewRouter()
+	r.Use(metricsMiddleware)
+	r.Methods("GET").Path("/metrics").Handler(promhttp.Handler())
+	r.Methods("GET").Path("/healthz").Handler(appHandler(healthzHandler))
+	r.Methods("GET").Path("/readyz").Handler(appHandler(readyzHandler))
 	r.Methods("GET").Path("/").Handler(appHandler(homeHandler))
-	r.Methods("GET").Path("/api/taxis").Handler(appHandler(listTaxiHandler))
-	r.Methods("GET").Path("/api/taxis/num_trips").Handler(appHandler(numTripsForCategoryHandler))
-	r.Methods("GET").Path("/api/taxis/supply_demand").Handler(appHandler(supplyAndDemandHandler))
-	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./static/")))
-	http.Handle("/", handlers.CombinedLoggingHandler(os.Stderr, r))
-	fmt.Println("Listening at localhost:8080...")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	r.Methods("GET").Path("/playback").Handler(appHandler(playbackPageHandler))
+	r.Methods("GET").Path("/dashboard").Handler(appHandler(dashboardPageHandler))
+	r.Methods("GET").Path("/scenarios").Handler(appHandler(scenariosPageHandler))
+	registerAPI(r)
+	r.PathPrefix("/").Handler(http.FileServer(http.Dir(staticDir)))
+	return r
+}
+
+// healthzHandler reports that the server is up.
+func healthzHandler(w http.ResponseWriter, r *http.Request) *appError {
+	return writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// readyzHandler reports whether the server can serve requests, i.e. whether
+// the database is reachable.
+func readyzHandler(w http.ResponseWriter, r *http.Request) *appError {
+	if err := ataxi.DB.Ping(r.Context()); err != nil {
+		return appErrorf(err, 503, "database unavailable: %v", err)
+	}
+	return writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// mapSettings configure the supply and demand map of the home page.
+type mapSettings struct {
+	GoogleMapsAPIKey string
+	TileURL          string
 }
 
 // homeHandler displays the home page.
 func homeHandler(w http.ResponseWriter, r *http.Request) *appError {
-	return mapTmpl.Execute(w, r, nil)
+	return mapTmpl.Execute(w, r, mapSettings{
+		GoogleMapsAPIKey: ataxi.Config.GoogleMapsAPIKey,
+		TileURL:          ataxi.Config.MapTiles,
+	})
+}
+
+const (
+	defaultTaxiLimit = 100
+	maxTaxiLimit     = 1000
+)
+
+// int32Param parses the named query param into dst, leaving dst nil if the
+// param is absent.
+func int32Param(params url.Values, name string, dst **int32) *appError {
+	param := params.Get(name)
+	if param == "" {
+		return nil
+	}
+	value, err := strconv.ParseInt(param, 10, 32)
+	if err != nil {
+		return appErrorf(err, 400, "%s param does not contain an int: \"%s\"", name, param)
+	}
+	v := int32(value)
+	*dst = &v
+	return nil
+}
+
+// uint32Param parses the named query param into dst, leaving dst nil if the
+// param is absent.
+func uint32Param(params url.Values, name string, dst **uint32) *appError {
+	param := params.Get(name)
+	if param == "" {
+		return nil
+	}
+	value, err := strconv.ParseUint(param, 10, 32)
+	if err != nil {
+		return appErrorf(err, 400, "%s param does not contain an unsigned int: \"%s\"", name, param)
+	}
+	v := uint32(value)
+	*dst = &v
+	return nil
+}
+
+// limitParam parses the limit query param, which defaults to defaultTaxiLimit
+// and is at most maxTaxiLimit. Streamed responses are not limited by default
+// and accept any positive limit; 0 means no limit.
+func limitParam(params url.Values, streamed bool) (int, *appError) {
+	param, ok := params["limit"]
+	if !ok {
+		if streamed {
+			return 0, nil
+		}
+		return defaultTaxiLimit, nil
+	}
+	limit, err := strconv.ParseInt(param[0], 10, 32)
+	if err != nil {
+		return 0, appErrorf(err, 400, "limit param does not contain an int: \"%s\"", param[0])
+	}
+	if streamed && limit < 1 {
+		return 0, appErrorf(nil, 400, "limit must be positive")
+	}
+	if !streamed && (limit < 1 || limit > maxTaxiLimit) {
+		return 0, appErrorf(nil, 400, "limit must be between 1 and %d", maxTaxiLimit)
+	}
+	return int(limit), nil
+}
+
+// taxiFilterParams builds a taxi filter from the request query params.
+func taxiFilterParams(params url.Values) (ataxi.TaxiFilter, *appError) {
+	var filter ataxi.TaxiFilter
+	int32Params := map[string]**int32{
+		"ox":       &filter.OX,
+		"oy":       &filter.OY,
+		"dx_super": &filter.DXSuper,
+		"dy_super": &filter.DYSuper,
+	}
+	for name, dst := range int32Params {
+		if e := int32Param(params, name, dst); e != nil {
+			return filter, e
+		}
+	}
+	uint32Params := map[string]**uint32{
+		"fips":            &filter.OFIPS,
+		"d_fips":          &filter.DFIPS,
+		"departure_start": &filter.DepartureStart,
+		"departure_end":   &filter.DepartureEnd,
+		"num_passengers":  &filter.NumPassengers,
+		"trip_category":   &filter.TripCategory,
+	}
+	for name, dst := range uint32Params {
+		if e := uint32Param(params, name, dst); e != nil {
+			return filter, e
+		}
+	}
+	if scenario := params.Get("scenario"); scenario != "" {
+		filter.Scenario = &scenario
+	}
+	return filter, nil
 }
 
-// listTaxiHandler returns a json list of taxis sorted by request field.
+// listTaxiHandler returns a list of taxis matching the request filters,
+// sorted by request field, as json or streamed as csv or ndjson. The total
+// number of matching taxis is returned in the X-Total-Count header and the
+// next page in the Link header.
 func listTaxiHandler(w http.ResponseWriter, r *http.Request) *appError {
+	format, e := negotiateFormat(r)
+	if e != nil {
+		return e
+	}
 	params := r.URL.Query()
 	orderBy := "departure_time"
-	if orderParam, ok := params["orderby"]; ok {
-		orderBy = orderParam[0]
-		if orderBy != "departure_time" {
-			orderBy = "num_passengers"
-		}
-	}
-	limit := 100
-	if limitParam, ok := params["limit"]; ok {
-		limit64, err := strconv.ParseInt(limitParam[0], 10, 32)
-		if err == nil {
-			if limit64 < 100 {
-				limit = int(limit64)
-			}
+	if orderParam := params.Get("orderby"); orderParam != "" {
+		orderBy = orderParam
+	}
+	limit, e := limitParam(params, format != formatJSON)
+	if e != nil {
+		return e
+	}
+	var offset int
+	if offsetParam, ok := params["offset"]; ok {
+		offset64, err := strconv.ParseInt(offsetParam[0], 10, 32)
+		if err != nil || offset64 < 0 {
+			return appErrorf(err, 400, "offset param does not contain a non-negative int: \"%s\"", offsetParam[0])
 		}
+		offset = int(offset64)
 	}
 	var withPassengers bool
 	if passengersParam, ok := params["passengers"]; ok {
 		withPassengers, _ = strconv.ParseBool(passengersParam[0])
 	}
-	taxis, err := ataxi.DB.ListTaxis(orderBy, limit, withPassengers)
+	filter, e := taxiFilterParams(params)
+	if e != nil {
+		return e
+	}
+	total, err := ataxi.DB.CountTaxis(r.Context(), filter)
 	if err != nil {
-		return appErrorf(err, 500, "could not list taxis: %v", err)
+		return dbError(r.Context(), err, "count taxis")
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if limit > 0 && offset+limit < total {
+		next := *r.URL
+		nextParams := r.URL.Query()
+		nextParams.Set("offset", strconv.Itoa(offset+limit))
+		nextParams.Set("limit", strconv.Itoa(limit))
+		next.RawQuery = nextParams.Encode()
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+	}
+
+	if format == formatJSON {
+		taxis, err := ataxi.DB.QueryTaxis(r.Context(), filter, orderBy, offset, limit, withPassengers)
+		if err != nil {
+			return dbError(r.Context(), err, "list taxis")
+		}
+		return writeJSON(w, taxis)
+	}
+	rw := newRowWriter(w, format, ataxi.Taxi{})
+	err = ataxi.DB.StreamTaxis(r.Context(), filter, orderBy, offset, limit, withPassengers, func(taxi *ataxi.Taxi) error {
+		return rw.Write(taxi)
+	})
+	if err == nil {
+		err = rw.Close()
 	}
-	jsonOutput, err := json.MarshalIndent(taxis, "", "  ")
 	if err != nil {
-		return appErrorf(err, 500, "failed to return taxi json data: %v", err)
+		return streamError(rw, dbError(r.Context(), err, "list taxis"))
 	}
-	w.Write(jsonOutput)
 	return nil
 }
 
-func supplyAndDemandHandler(w http.ResponseWriter, r *http.Request) *appError {
-	demandResults, err := ataxi.DB.GetDemandForPixels(1)
+// taxiLinks are the related resources of a taxi.
+type taxiLinks struct {
+	Self       string
+	Passengers []string
+}
+
+// taxiResource is a taxi together with links to its passengers.
+type taxiResource struct {
+	*ataxi.Taxi
+	Links taxiLinks
+}
+
+// passengerLinks are the related resources of a passenger.
+type passengerLinks struct {
+	Self string
+	Taxi string
+}
+
+// passengerResource is a passenger together with a link to its taxi.
+type passengerResource struct {
+	*ataxi.Passenger
+	Links passengerLinks
+}
+
+func newPassengerResource(passenger *ataxi.Passenger) passengerResource {
+	return passengerResource{
+		Passenger: passenger,
+		Links: passengerLinks{
+			Self: fmt.Sprintf("/api/passengers/%d", passenger.ID),
+			Taxi: fmt.Sprintf("/api/taxis/%d", passenger.TaxiID),
+		},
+	}
+}
+
+// idParam parses the id route variable.
+func idParam(r *http.Request) (uint, *appError) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
 	if err != nil {
-		return appErrorf(err, 500, "could not list demand for pixels: %v", err)
+		return 0, appErrorf(err, 400, "invalid id: \"%s\"", mux.Vars(r)["id"])
+	}
+	return uint(id), nil
+}
+
+// taxiHandler returns a taxi with its passengers.
+func taxiHandler(w http.ResponseWriter, r *http.Request) *appError {
+	id, e := idParam(r)
+	if e != nil {
+		return e
+	}
+	taxi, err := ataxi.DB.GetTaxi(r.Context(), id)
+	if errors.Is(err, ataxi.ErrNotFound) {
+		return appErrorf(err, 404, "no taxi with id %d", id)
+	} else if err != nil {
+		return dbError(r.Context(), err, "get taxi")
+	}
+	res := taxiResource{
+		Taxi:  taxi,
+		Links: taxiLinks{Self: fmt.Sprintf("/api/taxis/%d", taxi.ID)},
+	}
+	for _, passenger := range taxi.Passengers {
+		res.Links.Passengers = append(res.Links.Passengers, fmt.Sprintf("/api/passengers/%d", passenger.ID))
+	}
+	return writeJSON(w, res)
+}
+
+// listPassengersHandler returns a list of passengers, ordered by departure
+// time, or the passengers of a single taxi, as json or streamed as csv or
+// ndjson.
+func listPassengersHandler(w http.ResponseWriter, r *http.Request) *appError {
+	format, e := negotiateFormat(r)
+	if e != nil {
+		return e
+	}
+	params := r.URL.Query()
+	var passengers []ataxi.Passenger
+	var err error
+	if taxiParam, ok := params["taxi_id"]; ok {
+		taxiID, perr := strconv.ParseUint(taxiParam[0], 10, 32)
+		if perr != nil {
+			return appErrorf(perr, 400, "taxi_id param does not contain an id: \"%s\"", taxiParam[0])
+		}
+		// Check that the taxi exists without loading its passengers twice.
+		id := uint(taxiID)
+		taxis, terr := ataxi.DB.QueryTaxis(r.Context(), ataxi.TaxiFilter{ID: &id}, "departure_time", 0, 1, false)
+		if terr != nil {
+			return dbError(r.Context(), terr, "get taxi")
+		}
+		if len(taxis) == 0 {
+			return appErrorf(ataxi.ErrNotFound, 404, "no taxi with id %d", taxiID)
+		}
+		passengers, err = ataxi.DB.ListPassengersForTaxi(r.Context(), id)
+	} else {
+		limit, e := limitParam(params, format != formatJSON)
+		if e != nil {
+			return e
+		}
+		if format != formatJSON {
+			rw := newRowWriter(w, format, passengerResource{})
+			err = ataxi.DB.StreamPassengers(r.Context(), limit, func(passenger *ataxi.Passenger) error {
+				return rw.Write(newPassengerResource(passenger))
+			})
+			if err == nil {
+				err = rw.Close()
+			}
+			if err != nil {
+				return streamError(rw, dbError(r.Context(), err, "list passengers"))
+			}
+			return nil
+		}
+		passengers, err = ataxi.DB.ListPassengers(r.Context(), limit)
 	}
-	supplyResults, err := ataxi.DB.GetSupplyForPixels(1)
 	if err != nil {
-		return appErrorf(err, 500, "could not list supply for pixels: %v", err)
+		return dbError(r.Context(), err, "list passengers")
+	}
+	res := make([]passengerResource, len(passengers))
+	for i := range passengers {
+		res[i] = newPassengerResource(&passengers[i])
+	}
+	return writeFormat(w, format, res)
+}
+
+// passengerHandler returns a passenger.
+func passengerHandler(w http.ResponseWriter, r *http.Request) *appError {
+	id, e := idParam(r)
+	if e != nil {
+		return e
+	}
+	passenger, err := ataxi.DB.GetPassenger(r.Context(), id)
+	if errors.Is(err, ataxi.ErrNotFound) {
+		return appErrorf(err, 404, "no passenger with id %d", id)
+	} else if err != nil {
+		return dbError(r.Context(), err, "get passenger")
+	}
+	return writeJSON(w, newPassengerResource(passenger))
+}
+
+// avoStatsHandler returns the AVO, PMT and VMT of the taxis matching the
+// request filters, grouped by the group_by param.
+func avoStatsHandler(w http.ResponseWriter, r *http.Request) *appError {
+	format, e := negotiateFormat(r)
+	if e != nil {
+		return e
 	}
-	netTaxis := make(map[int32]*ataxi.SuperPixelDemand)
-	for _, result := range demandResults {
-		spd := &ataxi.SuperPixelDemand{
-			Count: -result.Count,
-			X:     result.X,
-			Y:     result.Y,
+	params := r.URL.Query()
+	groupBy := params.Get("group_by")
+	if groupBy == "" {
+		groupBy = "all"
+	}
+	valid := false
+	for _, grouping := range ataxi.AVOGroupings {
+		if groupBy == grouping {
+			valid = true
 		}
-		netTaxis[ataxi.HashCode(result.X, result.Y)] = spd
 	}
-	for _, result := range supplyResults {
-		spd := &ataxi.SuperPixelDemand{
-			Count: result.Count,
-			X:     result.X,
-			Y:     result.Y,
+	if !valid {
+		return appErrorf(nil, 400, "group_by must be one of %s", strings.Join(ataxi.AVOGroupings, ", "))
+	}
+	filter, e := taxiFilterParams(params)
+	if e != nil {
+		return e
+	}
+	stats, err := ataxi.DB.GetAVOStats(r.Context(), groupBy, filter)
+	if err != nil {
+		return dbError(r.Context(), err, "compute AVO statistics")
+	}
+	return writeFormat(w, format, stats)
+}
+
+// supplyDemandIntervals are the time series bucket sizes, in seconds, of the
+// supply and demand interval param.
+var supplyDemandIntervals = map[string]uint32{
+	"15m": 900,
+	"1h":  3600,
+}
+
+// supplyDemandSeries is the supply and demand time series of a superpixel.
+type supplyDemandSeries struct {
+	X      int32
+	Y      int32
+	Series []ataxi.SuperPixelNet
+}
+
+// supplyAndDemandHandler returns the net supply of taxis (supply - demand)
+// for each superpixel of the requested size within the requested time window.
+// With the interval param, it returns a time series per superpixel instead.
+func supplyAndDemandHandler(w http.ResponseWriter, r *http.Request) *appError {
+	format, e := negotiateFormat(r)
+	if e != nil {
+		return e
+	}
+	params := r.URL.Query()
+	query := ataxi.SupplyDemandQuery{Size: 1}
+	if sizeParam := params.Get("size"); sizeParam != "" {
+		size, err := strconv.ParseInt(sizeParam, 10, 32)
+		if err != nil {
+			return appErrorf(err, 400, "size param does not contain an int: \"%s\"", sizeParam)
+		}
+		if size < 1 || size > ataxi.MaxSuperPixelSize {
+			return appErrorf(nil, 400, "size must be between 1 and %d", ataxi.MaxSuperPixelSize)
 		}
-		netTaxis[ataxi.HashCode(result.X, result.Y)] = spd
+		query.Size = int32(size)
+	}
+	if e := uint32Param(params, "start", &query.Start); e != nil {
+		return e
 	}
-	var supplyDemand []ataxi.SuperPixelDemand
-	for _, sd := range netTaxis {
-		supplyDemand = append(supplyDemand, *sd)
+	if e := uint32Param(params, "end", &query.End); e != nil {
+		return e
 	}
-	jsonOutput, err := json.MarshalIndent(supplyDemand, "", "  ")
+	if scenario := params.Get("scenario"); scenario != "" {
+		query.Scenario = &scenario
+	}
+	if query.Start != nil && query.End != nil && *query.End <= *query.Start {
+		return appErrorf(nil, 400, "end must be after start")
+	}
+	if intervalParam := params.Get("interval"); intervalParam != "" {
+		bucket, ok := supplyDemandIntervals[intervalParam]
+		if !ok {
+			return appErrorf(nil, 400, "interval must be 15m or 1h")
+		}
+		query.Bucket = bucket
+	}
+
+	demandResults, err := ataxi.DB.GetDemandForPixels(r.Context(), query)
 	if err != nil {
-		return appErrorf(err, 500, "failed to return taxi json data: %v", err)
+		return dbError(r.Context(), err, "list demand for pixels")
 	}
-	w.Write(jsonOutput)
-	return nil
+	supplyResults, err := ataxi.DB.GetSupplyForPixels(r.Context(), query)
+	if err != nil {
+		return dbError(r.Context(), err, "list supply for pixels")
+	}
+	supplyDemand := ataxi.NetSupplyDemand(demandResults, supplyResults)
+	if query.Bucket == 0 || format != formatJSON {
+		// The rows of csv and ndjson time series carry their superpixel.
+		return writeFormat(w, format, supplyDemand)
+	}
+
+	// The results are ordered by superpixel, then time.
+	var series []supplyDemandSeries
+	for _, sd := range supplyDemand {
+		if n := len(series); n == 0 || series[n-1].X != sd.X || series[n-1].Y != sd.Y {
+			series = append(series, supplyDemandSeries{X: sd.X, Y: sd.Y})
+		}
+		last := &series[len(series)-1]
+		last.Series = append(last.Series, sd)
+	}
+	return writeJSON(w, series)
+}
+
+// numTrips is the number of trips of a trip category.
+type numTrips struct {
+	TripCategory int `json:"trip_category"`
+	NumTrips     int `json:"num_trips"`
 }
 
 func numTripsForCategoryHandler(w http.ResponseWriter, r *http.Request) *appError {
+	format, e := negotiateFormat(r)
+	if e != nil {
+		return e
+	}
 	params := r.URL.Query()
 	var category int
 	if categoryParam, ok := params["category"]; ok {
 		category64, err := strconv.ParseInt(categoryParam[0], 10, 32)
 		if err != nil {
-			return appErrorf(err, 422, "category param does not contain an int: \"%s\"", categoryParam[0])
+			return appErrorf(err, 400, "category param does not contain an int: \"%s\"", categoryParam[0])
 		}
 		category = int(category64)
 	}
@@ -125,27 +612,76 @@ func numTripsForCategoryHandler(w http.ResponseWriter, r *http.Request) *appErro
 	if cumulativeParam, ok := params["cumulative"]; ok {
 		cumulative, err = strconv.ParseBool(cumulativeParam[0])
 		if err != nil {
-			return appErrorf(err, 422, "cumulative is a boolean param: \"%s\" provided", cumulativeParam[0])
+			return appErrorf(err, 400, "cumulative is a boolean param: \"%s\" provided", cumulativeParam[0])
 		}
 	}
-	var numTrips int
+	res := numTrips{TripCategory: category}
 	if cumulative {
-		numTrips, err = ataxi.DB.GetCumulativeNumTripsForCategory(category)
+		res.NumTrips, err = ataxi.DB.GetCumulativeNumTripsForCategory(r.Context(), category)
 	} else {
-		numTrips, err = ataxi.DB.GetNumTripsForCategory(category)
+		res.NumTrips, err = ataxi.DB.GetNumTripsForCategory(r.Context(), category)
 	}
 	if err != nil {
-		return appErrorf(err, 500, "failed to return number of trips: %v", err)
+		return dbError(r.Context(), err, "count trips")
+	}
+	if format == formatJSON {
+		return writeJSON(w, res)
+	}
+	return writeRows(w, format, []numTrips{res})
+}
+
+// fipsList is the list of states and counties.
+type fipsList struct {
+	States   []ataxi.State
+	Counties []ataxi.County
+}
+
+// listFIPSHandler returns a json list of states and counties. The counties
+// can be restricted to a single state with the state param.
+func listFIPSHandler(w http.ResponseWriter, r *http.Request) *appError {
+	counties := ataxi.ListCounties()
+	if stateParam := r.URL.Query().Get("state"); stateParam != "" {
+		stateFIPS, err := strconv.ParseUint(stateParam, 10, 32)
+		if err != nil {
+			return appErrorf(err, 400, "state param does not contain a FIPS code: \"%s\"", stateParam)
+		}
+		var stateCounties []ataxi.County
+		for _, county := range counties {
+			if county.State.FIPS == uint32(stateFIPS) {
+				stateCounties = append(stateCounties, county)
+			}
+		}
+		counties = stateCounties
 	}
-	res := make(map[string]int)
-	res["trip_category"] = category
-	res["num_trips"] = numTrips
-	jsonOutput, err := json.MarshalIndent(res, "", "  ")
+	return writeJSON(w, fipsList{
+		States:   ataxi.ListStates(),
+		Counties: counties,
+	})
+}
+
+// fipsHandler returns the state (two digit code) or county (five digit code)
+// with the requested FIPS code.
+func fipsHandler(w http.ResponseWriter, r *http.Request) *appError {
+	code := mux.Vars(r)["fips"]
+	fips, err := strconv.ParseUint(code, 10, 32)
 	if err != nil {
-		return appErrorf(err, 500, "failed to return num trips json data: %v", err)
+		return appErrorf(err, 400, "invalid FIPS code: \"%s\"", code)
 	}
-	w.Write(jsonOutput)
-	return nil
+	var res interface{}
+	if len(code) <= 2 {
+		state, ok := ataxi.GetState(uint32(fips))
+		if !ok {
+			return appErrorf(nil, 404, "no state with FIPS code %s", code)
+		}
+		res = state
+	} else {
+		county, ok := ataxi.GetCounty(uint32(fips))
+		if !ok {
+			return appErrorf(nil, 404, "no county with FIPS code %s", code)
+		}
+		res = county
+	}
+	return writeJSON(w, res)
 }
 
 type appHandler func(http.ResponseWriter, *http.Request) *appError
@@ -154,15 +690,88 @@ type appError struct {
 	Error   error
 	Message string
 	Code    int
+	Fields  []fieldError // errors of individual request params
+}
+
+// errorBody is the json body of an error response.
+type errorBody struct {
+	Code    int          `json:"code"`
+	Message string       `json:"message"`
+	Fields  []fieldError `json:"fields,omitempty"`
+}
+
+// requestTimeout bounds a request, if positive: its database queries are
+// canceled once it expires, and the handler responds with an error.
+// streamTimeout bounds the requests of streamHandlers instead.
+var requestTimeout, streamTimeout time.Duration
+
+// streamHandler is an appHandler whose response may be streamed from the
+// database for longer than requestTimeout, such as a csv export.
+type streamHandler appHandler
+
+func (fn streamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	appHandler(fn).serve(w, r, streamTimeout)
 }
 
 func (fn appHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fn.serve(w, r, requestTimeout)
+}
+
+func (fn appHandler) serve(w http.ResponseWriter, r *http.Request, timeout time.Duration) {
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
 	if e := fn(w, r); e != nil { // e is *appError, not os.Error.
 		log.Printf("Handler error: status code: %d, message: %s, underlying err: %#v",
 			e.Code, e.Message, e.Error)
 
-		http.Error(w, e.Message, e.Code)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(e.Code)
+		json.NewEncoder(w).Encode(struct {
+			Error errorBody `json:"error"`
+		}{errorBody{Code: e.Code, Message: e.Message, Fields: e.Fields}})
+	}
+}
+
+// writeJSON writes v as indented json.
+func writeJSON(w http.ResponseWriter, v interface{}) *appError {
+	jsonOutput, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return appErrorf(err, 500, "failed to return json data: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(jsonOutput)
+	return nil
+}
+
+// statusClientClosedRequest is the non-standard status of the requests whose
+// client went away before they were answered.
+const statusClientClosedRequest = 499
+
+// dbError maps the error of a database query to a response: 400 for invalid
+// arguments, 404 for missing records, 501 for queries the database does not
+// support, and 504 or 499 for queries canceled by a timeout or by the client.
+// Other errors are only logged, and answered with a generic 500.
+func dbError(ctx context.Context, err error, action string) *appError {
+	switch {
+	case errors.Is(err, ataxi.ErrInvalidArgument):
+		return appErrorf(err, 400, "could not %s: %v", action, err)
+	case errors.Is(err, ataxi.ErrNotFound):
+		return appErrorf(err, 404, "could not %s: %v", action, err)
+	case errors.Is(err, ataxi.ErrSpatialUnsupported):
+		return appErrorf(err, 501, "could not %s: the database has no spatial support, use the postgres driver", action)
+	// Not every driver wraps the context error of the queries it cancels.
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return appErrorf(err, 504, "could not %s: the query timed out", action)
+	case errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled):
+		return appErrorf(err, statusClientClosedRequest, "could not %s: the request was canceled", action)
 	}
+	// The error may expose queries or the database address, so only log it.
+	log.Printf("Could not %s: %v", action, err)
+	return appErrorf(err, 500, "could not %s: internal server error", action)
 }
 
 func appErrorf(err error, code int, format string, v ...interface{}) *appError {
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/webapps/ataxi"
+)
+
+func TestMalformedParamsAreBadRequests(t *testing.T) {
+	params := url.Values{"ox": {"x"}, "d_fips": {"-1"}, "limit": {"ten"}}
+	var ox *int32
+	var dFIPS *uint32
+	appErrors := map[string]*appError{
+		"int32Param":  int32Param(params, "ox", &ox),
+		"uint32Param": uint32Param(params, "d_fips", &dFIPS),
+	}
+	_, appErrors["limitParam"] = limitParam(params, false)
+	for name, e := range appErrors {
+		if e == nil {
+			t.Errorf("%s: got no error", name)
+		} else if e.Code != 400 {
+			t.Errorf("%s: got status %d, want 400", name, e.Code)
+		}
+	}
+}
+
+func TestDBErrorHidesInternalErrors(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+		want string
+	}{
+		{fmt.Errorf("%w: limit above 1000", ataxi.ErrInvalidArgument), 400, "could not list taxis: invalid argument: limit above 1000"},
+		{errors.New("dial tcp 10.0.0.5:3306: connection refused"), 500, "could not list taxis: internal server error"},
+	}
+	for _, test := range tests {
+		e := dbError(context.Background(), test.err, "list taxis")
+		if e.Code != test.code || e.Message != test.want {
+			t.Errorf("%v: got %d %q, want %d %q", test.err, e.Code, e.Message, test.code, test.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/webapps/ataxi"
+)
+
+// etagMatches reports whether an If-None-Match header matches etag. Weak
+// validators match their strong counterpart.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheEnabled is set when the query cache is on, with a cache_interval above
+// zero. Without it, every ETag would cost a dataset version query.
+var cacheEnabled bool
+
+// cacheable serves the responses of fn with the dataset version as their
+// ETag, so that clients can revalidate them with If-None-Match. The responses
+// only change when the tables are reloaded. Requests whose If-None-Match
+// matches the current version are answered with 304 Not Modified. Responses
+// are served without ETag when the cache is off.
+func cacheable(fn appHandler) appHandler {
+	return func(w http.ResponseWriter, r *http.Request) *appError {
+		if !cacheEnabled {
+			return fn(w, r)
+		}
+		version, err := ataxi.DB.DatasetVersion(r.Context())
+		if err != nil {
+			log.Printf("Could not get dataset version, serving without ETag: %v", err)
+			return fn(w, r)
+		}
+		format, e := negotiateFormat(r)
+		if e != nil {
+			return e
+		}
+		// Each format is a different representation of the resource.
+		etag := `"` + version + "-" + format + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Vary", "Accept")
+		if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+		if e := fn(w, r); e != nil {
+			// Errors are not cacheable.
+			w.Header().Del("ETag")
+			w.Header().Del("Cache-Control")
+			w.Header().Del("Vary")
+			return e
+		}
+		return nil
+	}
+}
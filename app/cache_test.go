@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/webapps/ataxi"
+)
+
+// versionedDB is a database that only has a dataset version.
+type versionedDB struct {
+	ataxi.RideSharingDatabase
+	version int
+}
+
+func (db *versionedDB) DatasetVersion(ctx context.Context) (string, error) {
+	return strconv.Itoa(db.version), nil
+}
+
+// serveCacheable serves a request for a cacheable handler.
+func serveCacheable(accept string, ifNoneMatch string) *httptest.ResponseRecorder {
+	handler := cacheable(func(w http.ResponseWriter, r *http.Request) *appError {
+		w.Write([]byte("ok"))
+		return nil
+	})
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/taxis/num_trips", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	if e := handler(rec, req); e != nil {
+		rec.Code = e.Code
+	}
+	return rec
+}
+
+func TestCacheable(t *testing.T) {
+	db := &versionedDB{version: 1}
+	saved := ataxi.DB
+	ataxi.DB = db
+	cacheEnabled = true
+	defer func() {
+		ataxi.DB = saved
+		cacheEnabled = false
+	}()
+
+	rec := serveCacheable("", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got %d with ETag %q, want 200 with an ETag", rec.Code, etag)
+	}
+	if rec := serveCacheable("", etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("If-None-Match %s: got %d %q, want 304 without a body", etag, rec.Code, rec.Body.String())
+	}
+	if rec := serveCacheable("", "W/"+etag); rec.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match W/%s: got %d, want 304", etag, rec.Code)
+	}
+
+	csv := serveCacheable("text/csv", "").Header().Get("ETag")
+	if csv == "" || csv == etag {
+		t.Errorf("got CSV ETag %q and JSON ETag %q, want different ones", csv, etag)
+	}
+	if rec := serveCacheable("text/csv", etag); rec.Code != http.StatusOK {
+		t.Errorf("CSV with the JSON ETag: got %d, want 200", rec.Code)
+	}
+
+	db.version++
+	rec = serveCacheable("", etag)
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Errorf("after a new dataset version: got %d %q, want 200 \"ok\"", rec.Code, rec.Body.String())
+	}
+	if bumped := rec.Header().Get("ETag"); bumped == etag {
+		t.Errorf("after a new dataset version: got the same ETag %s", bumped)
+	}
+}
+
+func TestCacheableWithoutCache(t *testing.T) {
+	// With the cache off, the dataset version is not read: ataxi.DB is not
+	// even set here.
+	cacheEnabled = false
+	handler := cacheable(func(w http.ResponseWriter, r *http.Request) *appError {
+		w.Write([]byte("ok"))
+		return nil
+	})
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/taxis/num_trips", nil)
+	req.Header.Set("If-None-Match", "*")
+	if e := handler(rec, req); e != nil {
+		t.Fatal(e.Error)
+	}
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Errorf("got %d %q, want 200 \"ok\"", rec.Code, rec.Body.String())
+	}
+	if etag := rec.Header().Get("ETag"); etag != "" {
+		t.Errorf("got ETag %s, want none", etag)
+	}
+}
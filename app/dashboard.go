@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/webapps/ataxi"
+)
+
+var dashboardTmpl *appTemplate
+
+// dashboardPageHandler displays the county and state AVO dashboard.
+func dashboardPageHandler(w http.ResponseWriter, r *http.Request) *appError {
+	return dashboardTmpl.Execute(w, r, nil)
+}
+
+// regionAVOsError maps the error of reading region_avo results to a response.
+func regionAVOsError(err error) *appError {
+	if os.IsNotExist(err) {
+		return appErrorf(err, 404, "no AVO results, run region_avo first")
+	}
+	return appErrorf(err, 500, "could not read AVO results: %v", err)
+}
+
+// stateAVOsHandler returns the AVO, PMT and VMT of every state written by
+// region_avo.
+func stateAVOsHandler(w http.ResponseWriter, r *http.Request) *appError {
+	format, e := negotiateFormat(r)
+	if e != nil {
+		return e
+	}
+	states, err := ataxi.ReadStateAVOs(ataxi.DefaultStateAVOsFile)
+	if err != nil {
+		return regionAVOsError(err)
+	}
+	return writeFormat(w, format, states)
+}
+
+// countyAVOsHandler returns the AVO, PMT and VMT of every county written by
+// region_avo, or of the counties of the state param.
+func countyAVOsHandler(w http.ResponseWriter, r *http.Request) *appError {
+	format, e := negotiateFormat(r)
+	if e != nil {
+		return e
+	}
+	var state *uint32
+	if e := uint32Param(r.URL.Query(), "state", &state); e != nil {
+		return e
+	}
+	counties, err := ataxi.ReadCountyAVOs(ataxi.DefaultCountyAVOsFile)
+	if err != nil {
+		return regionAVOsError(err)
+	}
+	if state != nil {
+		var inState []ataxi.RegionAVO
+		for _, county := range counties {
+			if ataxi.StateFIPS(county.FIPS) == *state {
+				inState = append(inState, county)
+			}
+		}
+		counties = inState
+	}
+	return writeFormat(w, format, counties)
+}
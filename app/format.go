@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The response formats of the API. CSV and NDJSON responses are written one
+// row at a time.
+const (
+	formatJSON   = "json"
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+// formatTypes maps the media types of the Accept header to response formats.
+var formatTypes = map[string]string{
+	"application/json":     formatJSON,
+	"text/csv":             formatCSV,
+	"application/x-ndjson": formatNDJSON,
+	"application/ndjson":   formatNDJSON,
+}
+
+// formatContentTypes are the Content-Type headers of the streamed formats.
+var formatContentTypes = map[string]string{
+	formatCSV:    "text/csv; charset=utf-8",
+	formatNDJSON: "application/x-ndjson; charset=utf-8",
+}
+
+// negotiateFormat returns the response format of a request: the format param
+// if given, otherwise the supported media type of the Accept header with the
+// highest quality value, the first listed on ties, defaulting to json.
+func negotiateFormat(r *http.Request) (string, *appError) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		switch format {
+		case formatJSON, formatCSV, formatNDJSON:
+			return format, nil
+		}
+		return "", appErrorf(nil, 400, "format must be json, csv or ndjson")
+	}
+	best, bestQuality := formatJSON, 0.0
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		format, ok := formatTypes[mediaType]
+		if mediaType == "*/*" {
+			format, ok = formatJSON, true
+		}
+		if !ok {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = format, quality
+		}
+	}
+	return best, nil
+}
+
+// rowWriter writes the rows of a csv or ndjson response as they are produced.
+type rowWriter interface {
+	// Write writes a row, a struct or a pointer to a struct.
+	Write(row interface{}) error
+
+	// Close finishes the response.
+	Close() error
+
+	// Started reports whether anything has been written to the response.
+	Started() bool
+}
+
+// flushInterval is how often the rows written to a streamed response are
+// flushed to the client.
+const flushInterval = time.Second
+
+// periodicFlusher flushes a streamed response at most every flushInterval, so
+// the client receives rows while the rest are produced.
+type periodicFlusher struct {
+	w    http.ResponseWriter
+	last time.Time
+}
+
+func newPeriodicFlusher(w http.ResponseWriter) periodicFlusher {
+	return periodicFlusher{w: w, last: time.Now()}
+}
+
+// due reports whether the response should be flushed.
+func (f *periodicFlusher) due() bool {
+	return time.Since(f.last) >= flushInterval
+}
+
+func (f *periodicFlusher) flush() {
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	f.last = time.Now()
+}
+
+// newRowWriter returns a writer of rows of the same type as sample in the
+// streamed format, and sets the response's Content-Type.
+func newRowWriter(w http.ResponseWriter, format string, sample interface{}) rowWriter {
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	if format == formatCSV {
+		return &csvRowWriter{w: csv.NewWriter(w), rowType: indirectType(reflect.TypeOf(sample)), flusher: newPeriodicFlusher(w)}
+	}
+	return &ndjsonRowWriter{enc: json.NewEncoder(w), flusher: newPeriodicFlusher(w)}
+}
+
+// writeFormat writes the slice rows as a json list, or row by row in a
+// streamed format.
+func writeFormat(w http.ResponseWriter, format string, rows interface{}) *appError {
+	if format == formatJSON {
+		return writeJSON(w, rows)
+	}
+	return writeRows(w, format, rows)
+}
+
+// writeRows writes the elements of the slice rows in the streamed format.
+func writeRows(w http.ResponseWriter, format string, rows interface{}) *appError {
+	v := reflect.ValueOf(rows)
+	rw := newRowWriter(w, format, reflect.Zero(v.Type().Elem()).Interface())
+	for i := 0; i < v.Len(); i++ {
+		if err := rw.Write(v.Index(i).Interface()); err != nil {
+			return streamError(rw, appErrorf(err, 500, "could not write rows: %v", err))
+		}
+	}
+	if err := rw.Close(); err != nil {
+		return streamError(rw, appErrorf(err, 500, "could not write rows: %v", err))
+	}
+	return nil
+}
+
+// streamError returns the error of a streamed response, unless the response
+// has already started. Then the response is aborted, so that the client sees
+// a broken connection rather than a truncated body that looks complete.
+func streamError(rw rowWriter, e *appError) *appError {
+	if rw.Started() {
+		log.Printf("Response aborted, %s", e.Message)
+		panic(http.ErrAbortHandler)
+	}
+	return e
+}
+
+type ndjsonRowWriter struct {
+	enc     *json.Encoder
+	flusher periodicFlusher
+	started bool
+}
+
+func (rw *ndjsonRowWriter) Write(row interface{}) error {
+	rw.started = true
+	if err := rw.enc.Encode(row); err != nil {
+		return err
+	}
+	if rw.flusher.due() {
+		rw.flusher.flush()
+	}
+	return nil
+}
+
+func (rw *ndjsonRowWriter) Close() error {
+	return nil
+}
+
+func (rw *ndjsonRowWriter) Started() bool {
+	return rw.started
+}
+
+// csvRowWriter writes the scalar fields of struct rows as csv columns, headed
+// by their field names. Fields of embedded structs are flattened; slices and
+// nested structs, such as a taxi's passengers, are left out.
+type csvRowWriter struct {
+	w       *csv.Writer
+	rowType reflect.Type
+	flusher periodicFlusher
+	started bool
+}
+
+func (rw *csvRowWriter) writeHeader() error {
+	rw.started = true
+	return rw.w.Write(csvColumns(rw.rowType))
+}
+
+func (rw *csvRowWriter) Write(row interface{}) error {
+	if !rw.started {
+		if err := rw.writeHeader(); err != nil {
+			return err
+		}
+	}
+	if err := rw.w.Write(csvRecord(reflect.ValueOf(row))); err != nil {
+		return err
+	}
+	if rw.flusher.due() {
+		rw.w.Flush()
+		if err := rw.w.Error(); err != nil {
+			return err
+		}
+		rw.flusher.flush()
+	}
+	return nil
+}
+
+func (rw *csvRowWriter) Close() error {
+	if !rw.started {
+		if err := rw.writeHeader(); err != nil {
+			return err
+		}
+	}
+	rw.w.Flush()
+	return rw.w.Error()
+}
+
+func (rw *csvRowWriter) Started() bool {
+	return rw.started
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// csvField reports whether a struct field is written as a csv column.
+func csvField(field reflect.StructField) bool {
+	if field.PkgPath != "" || field.Anonymous {
+		return false
+	}
+	t := indirectType(field.Type)
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Interface, reflect.Func, reflect.Chan:
+		return false
+	case reflect.Struct:
+		return t == timeType
+	}
+	return true
+}
+
+// embeddedStruct reports whether a struct field is an embedded struct whose
+// fields are flattened into the csv columns.
+func embeddedStruct(field reflect.StructField) bool {
+	return field.Anonymous && indirectType(field.Type).Kind() == reflect.Struct
+}
+
+// csvColumns returns the csv header of a struct type.
+func csvColumns(t reflect.Type) []string {
+	var columns []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if embeddedStruct(field) {
+			columns = append(columns, csvColumns(indirectType(field.Type))...)
+		} else if csvField(field) {
+			columns = append(columns, field.Name)
+		}
+	}
+	return columns
+}
+
+// csvRecord returns the csv columns of a struct value. The columns of nil
+// pointers are left empty.
+func csvRecord(v reflect.Value) []string {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return make([]string, len(csvColumns(indirectType(v.Type()))))
+		}
+		v = v.Elem()
+	}
+	var record []string
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if embeddedStruct(field) {
+			record = append(record, csvRecord(v.Field(i))...)
+		} else if csvField(field) {
+			record = append(record, csvValue(v.Field(i)))
+		}
+	}
+	return record
+}
+
+// csvValue formats a scalar value as a csv column.
+func csvValue(v reflect.Value) string {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	case reflect.Struct:
+		return v.Interface().(time.Time).Format(time.RFC3339)
+	}
+	return fmt.Sprint(v.Interface())
+}
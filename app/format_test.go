@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStreamErrorBeforeRows(t *testing.T) {
+	rw := newRowWriter(httptest.NewRecorder(), formatNDJSON, struct{ ID int }{})
+	e := appErrorf(errors.New("failed"), 500, "failed")
+	if got := streamError(rw, e); got != e {
+		t.Errorf("got %v, want the error itself", got)
+	}
+}
+
+func TestStreamErrorAbortsStartedResponse(t *testing.T) {
+	for _, format := range []string{formatCSV, formatNDJSON} {
+		rw := newRowWriter(httptest.NewRecorder(), format, struct{ ID int }{})
+		if err := rw.Write(struct{ ID int }{1}); err != nil {
+			t.Fatal(err)
+		}
+		func() {
+			defer func() {
+				if r := recover(); r != http.ErrAbortHandler {
+					t.Errorf("%s: got panic %v, want http.ErrAbortHandler", format, r)
+				}
+			}()
+			streamError(rw, appErrorf(errors.New("failed"), 500, "failed"))
+		}()
+	}
+}
+
+func TestRowWriterFlushes(t *testing.T) {
+	for _, format := range []string{formatCSV, formatNDJSON} {
+		rec := httptest.NewRecorder()
+		rw := newRowWriter(rec, format, struct{ ID int }{})
+		rw.Write(struct{ ID int }{1})
+		if rec.Flushed {
+			t.Errorf("%s: flushed before flushInterval", format)
+		}
+		switch rw := rw.(type) {
+		case *csvRowWriter:
+			rw.flusher.last = rw.flusher.last.Add(-flushInterval)
+		case *ndjsonRowWriter:
+			rw.flusher.last = rw.flusher.last.Add(-flushInterval)
+		}
+		rw.Write(struct{ ID int }{2})
+		if !rec.Flushed || rec.Body.Len() == 0 {
+			t.Errorf("%s: not flushed after flushInterval", format)
+		}
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		query  string
+		accept string
+		want   string
+	}{
+		{"", "", formatJSON},
+		{"", "text/csv", formatCSV},
+		{"", "text/html, application/x-ndjson", formatNDJSON},
+		{"", "text/csv;q=0.1, application/json", formatJSON},
+		{"", "application/json;q=0.5, text/csv;q=0.8", formatCSV},
+		{"", "text/csv, application/json", formatCSV},
+		{"", "text/csv;q=0, */*;q=0.1", formatJSON},
+		{"", "text/csv;q=x, application/x-ndjson;q=0.2", formatNDJSON},
+		{"format=ndjson", "text/csv", formatNDJSON},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/api/taxis?"+test.query, nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		format, e := negotiateFormat(r)
+		if e != nil {
+			t.Errorf("%q, Accept %q: %s", test.query, test.accept, e.Message)
+		} else if format != test.want {
+			t.Errorf("%q, Accept %q: got %s, want %s", test.query, test.accept, format, test.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webapps/ataxi"
+)
+
+var requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "ataxi",
+	Name:      "http_request_duration_seconds",
+	Help:      "Latency of HTTP requests by route.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"route", "method", "code"})
+
+func init() {
+	prometheus.MustRegister(requestDuration, ataxi.DBQueryDuration, ataxi.CacheRequests)
+}
+
+// statusRecorder remembers the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.code = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// Flush flushes the response if the underlying writer supports it, so that
+// streamed responses stream through the middleware.
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// metricsMiddleware records the latency of every request in requestDuration,
+// labelled with the template of the matched route.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		// Deferred, to also record the streamed responses that are aborted.
+		defer func() {
+			route := "unknown"
+			if current := mux.CurrentRoute(r); current != nil {
+				if template, err := current.GetPathTemplate(); err == nil {
+					route = template
+				}
+			}
+			requestDuration.WithLabelValues(route, r.Method, strconv.Itoa(rec.code)).
+				Observe(time.Since(start).Seconds())
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
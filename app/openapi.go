@@ -0,0 +1,696 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/webapps/ataxi"
+)
+
+// apiParam describes a parameter of an API route. The OpenAPI document is
+// generated from it and requests are validated against it.
+type apiParam struct {
+	Name        string
+	In          string // "query" or "path"
+	Type        string // "integer", "number", "string" or "boolean"
+	Description string
+	Required    bool
+	Minimum     *int64
+	Maximum     *int64
+	Enum        []string
+}
+
+// apiRoute describes a GET route of the API.
+type apiRoute struct {
+	Path        string // mux path template
+	Summary     string
+	Description string
+	Params      []apiParam
+	Response    interface{}   // sample of the json response body
+	OneOf       []interface{} // samples of the json response bodies, if it varies
+	Formats     bool          // whether csv and ndjson responses are supported
+	Cacheable   bool          // whether responses carry the dataset version ETag
+	Streamed    bool          // whether responses are streamed from the database, see streamHandler
+	Handler     appHandler
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+func intQuery(name string, description string, min int64, max int64) apiParam {
+	return apiParam{Name: name, In: "query", Type: "integer", Description: description,
+		Minimum: int64Ptr(min), Maximum: int64Ptr(max)}
+}
+
+func int32Query(name string, description string) apiParam {
+	return intQuery(name, description, math.MinInt32, math.MaxInt32)
+}
+
+func uint32Query(name string, description string) apiParam {
+	return intQuery(name, description, 0, math.MaxUint32)
+}
+
+func numberQuery(name string, description string, min int64, max int64) apiParam {
+	return apiParam{Name: name, In: "query", Type: "number", Description: description,
+		Minimum: int64Ptr(min), Maximum: int64Ptr(max)}
+}
+
+func boolQuery(name string, description string) apiParam {
+	return apiParam{Name: name, In: "query", Type: "boolean", Description: description}
+}
+
+func stringQuery(name string, description string) apiParam {
+	return apiParam{Name: name, In: "query", Type: "string", Description: description}
+}
+
+func enumQuery(name string, description string, values ...string) apiParam {
+	return apiParam{Name: name, In: "query", Type: "string", Description: description, Enum: values}
+}
+
+func required(p apiParam) apiParam {
+	p.Required = true
+	return p
+}
+
+func idPath(name string, description string) apiParam {
+	return apiParam{Name: name, In: "path", Type: "integer", Description: description, Required: true,
+		Minimum: int64Ptr(0), Maximum: int64Ptr(math.MaxUint32)}
+}
+
+var formatParam = enumQuery("format", "response format, overrides the Accept header",
+	formatJSON, formatCSV, formatNDJSON)
+
+// taxiFilterParamSpecs are the params parsed by taxiFilterParams.
+var taxiFilterParamSpecs = []apiParam{
+	int32Query("ox", "X coord of origin pixel"),
+	int32Query("oy", "Y coord of origin pixel"),
+	int32Query("dx_super", "X coord of destination superpixel"),
+	int32Query("dy_super", "Y coord of destination superpixel"),
+	uint32Query("fips", "FIPS code of origin county"),
+	uint32Query("d_fips", "FIPS code of destination county"),
+	uint32Query("departure_start", "earliest departure time in seconds (inclusive)"),
+	uint32Query("departure_end", "latest departure time in seconds (exclusive)"),
+	uint32Query("num_passengers", "occupancy of the taxi"),
+	uint32Query("trip_category", "only taxis carrying a passenger of this trip category"),
+	stringQuery("scenario", "only taxis of this scenario"),
+}
+
+func params(groups ...[]apiParam) []apiParam {
+	var all []apiParam
+	for _, group := range groups {
+		all = append(all, group...)
+	}
+	return all
+}
+
+func supplyDemandIntervalNames() []string {
+	var names []string
+	for name := range supplyDemandIntervals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// apiRoutes are the routes of the API. They are registered on the router, and
+// described in the OpenAPI document, from this table.
+var apiRoutes []apiRoute
+
+func init() {
+	apiRoutes = []apiRoute{
+		{
+			Path:        "/api/taxis",
+			Summary:     "List taxis",
+			Description: "Returns the taxis matching the filters. The total number of matching taxis is returned in the X-Total-Count header and the next page in the Link header. CSV and NDJSON responses are streamed and not limited by default.",
+			Params: params([]apiParam{
+				enumQuery("orderby", "field to sort taxis by", ataxi.TaxiOrderings...),
+				boolQuery("passengers", "return the passengers of each taxi"),
+				intQuery("limit", fmt.Sprintf("number of taxis to return (default %d, at most %d for json)", defaultTaxiLimit, maxTaxiLimit), 1, math.MaxInt32),
+				intQuery("offset", "number of matching taxis to skip", 0, math.MaxInt32),
+			}, taxiFilterParamSpecs, []apiParam{formatParam}),
+			Response: []ataxi.Taxi{},
+			Formats:  true,
+			Streamed: true,
+			Handler:  listTaxiHandler,
+		},
+		{
+			Path:     "/api/taxis/{id:[0-9]+}",
+			Summary:  "Get a taxi",
+			Params:   []apiParam{idPath("id", "taxi id")},
+			Response: taxiResource{},
+			Handler:  taxiHandler,
+		},
+		{
+			Path:    "/api/taxis/num_trips",
+			Summary: "Count trips of a trip category",
+			Params: []apiParam{
+				required(intQuery("category", "trip category", 1, 4)),
+				boolQuery("cumulative", "count the trips of every category up to category"),
+				formatParam,
+			},
+			Response:  numTrips{},
+			Formats:   true,
+			Cacheable: true,
+			Handler:   numTripsForCategoryHandler,
+		},
+		{
+			Path:        "/api/taxis/supply_demand",
+			Summary:     "Supply and demand per superpixel",
+			Description: "Returns the supply (taxis made empty), demand (taxis departing) and net supply - demand of taxis per superpixel. With the interval param, json responses hold a time series per superpixel instead.",
+			Params: []apiParam{
+				intQuery("size", "superpixel size in pixels (default 1)", 1, ataxi.MaxSuperPixelSize),
+				uint32Query("start", "start of the time window in seconds (inclusive)"),
+				uint32Query("end", "end of the time window in seconds (exclusive)"),
+				enumQuery("interval", "time series bucket size", supplyDemandIntervalNames()...),
+				stringQuery("scenario", "only count the taxis of this scenario"),
+				formatParam,
+			},
+			Response:  []ataxi.SuperPixelNet{},
+			Formats:   true,
+			Cacheable: true,
+			Handler:   supplyAndDemandHandler,
+		},
+		{
+			Path:        "/api/taxis/playback",
+			Summary:     "Play back taxi trips",
+			Description: "Returns the trips of the taxis matching the filters in departure time order: their origin, destination, departure and made empty times and occupancy. CSV and NDJSON responses are streamed and not limited by default.",
+			Params: params([]apiParam{
+				intQuery("limit", fmt.Sprintf("number of trips to return (default %d, at most %d for json)", defaultTaxiLimit, maxTaxiLimit), 1, math.MaxInt32),
+			}, taxiFilterParamSpecs, []apiParam{formatParam}),
+			Response: []fleetTrip{},
+			Formats:  true,
+			Streamed: true,
+			Handler:  playbackHandler,
+		},
+		{
+			Path:        "/api/taxis/active",
+			Summary:     "Active taxis per minute",
+			Description: "Returns the number of taxis matching the filters that are on the road in every minute of the simulation. A taxi is active from the minute it departs through the minute it drops off its last passenger.",
+			Params:      params(taxiFilterParamSpecs, []apiParam{formatParam}),
+			Response:    []ataxi.ActiveTaxiCount{},
+			Formats:     true,
+			Cacheable:   true,
+			Streamed:    true,
+			Handler:     activeTaxisHandler,
+		},
+		{
+			Path:        "/api/avo",
+			Summary:     "AVO statistics",
+			Description: "Returns the number of taxis and passengers, PMT, VMT and AVO of the taxis matching the filters.",
+			Params: params([]apiParam{
+				enumQuery("group_by", "grouping of the statistics (default all)", ataxi.AVOGroupings...),
+			}, taxiFilterParamSpecs, []apiParam{formatParam}),
+			Response:  []ataxi.AVOStats{},
+			Formats:   true,
+			Cacheable: true,
+			Handler:   avoStatsHandler,
+		},
+		{
+			Path:        "/api/avo/states",
+			Summary:     "AVO results per state",
+			Description: "Returns the AVO, PMT and VMT of every state from the state_avos.csv written by region_avo.",
+			Params:      []apiParam{formatParam},
+			Response:    []ataxi.RegionAVO{},
+			Formats:     true,
+			Handler:     stateAVOsHandler,
+		},
+		{
+			Path:        "/api/avo/counties",
+			Summary:     "AVO results per county",
+			Description: "Returns the AVO, PMT and VMT of every county from the county_avos.csv written by region_avo.",
+			Params: []apiParam{
+				uint32Query("state", "only return the counties of the state with this FIPS code"),
+				formatParam,
+			},
+			Response: []ataxi.RegionAVO{},
+			Formats:  true,
+			Handler:  countyAVOsHandler,
+		},
+		{
+			Path:        "/api/runs",
+			Summary:     "List runs",
+			Description: "Returns the runs of region_avo and db_populate whose summaries are stored, newest first.",
+			Response:    []ataxi.Run{},
+			Cacheable:   true,
+			Handler:     listRunsHandler,
+		},
+		{
+			Path:        "/api/summaries/states",
+			Summary:     "State summaries of a run",
+			Description: "Returns the fleet size, PMT, VMT and AVO of every state of a run, as stored by region_avo or db_populate.",
+			Params: []apiParam{
+				uint32Query("run", "id of the run (default the latest)"),
+				formatParam,
+			},
+			Response:  []ataxi.StateSummary{},
+			Formats:   true,
+			Cacheable: true,
+			Handler:   stateSummariesHandler,
+		},
+		{
+			Path:        "/api/summaries/counties",
+			Summary:     "County summaries of a run",
+			Description: "Returns the fleet size, PMT, VMT and AVO of every county of a run, as stored by region_avo or db_populate.",
+			Params: []apiParam{
+				uint32Query("run", "id of the run (default the latest)"),
+				uint32Query("state", "only return the counties of the state with this FIPS code"),
+				formatParam,
+			},
+			Response:  []ataxi.CountySummary{},
+			Formats:   true,
+			Cacheable: true,
+			Handler:   countySummariesHandler,
+		},
+		{
+			Path:        "/api/scenarios",
+			Summary:     "List scenarios",
+			Description: "Returns the scenarios (simulation runs) of the stored taxis, with their number of taxis and passengers.",
+			Response:    []ataxi.ScenarioSummary{},
+			Cacheable:   true,
+			Handler:     listScenariosHandler,
+		},
+		{
+			Path:        "/api/scenarios/compare",
+			Summary:     "Compare two scenarios",
+			Description: "Returns the fleet size, AVO and supply and demand of scenarios a and b, and their difference b - a, per county or hour.",
+			Params: []apiParam{
+				required(stringQuery("a", "baseline scenario")),
+				required(stringQuery("b", "scenario compared to the baseline")),
+				enumQuery("group_by", "grouping of the comparison (default county)", ataxi.SupplyDemandGroupings...),
+			},
+			Response:  []ataxi.ScenarioComparison{},
+			Cacheable: true,
+			Handler:   compareScenariosHandler,
+		},
+		{
+			Path:        "/api/spatial/taxis/radius",
+			Summary:     "List taxis departing within a radius",
+			Description: "Returns the taxis matching the filters departing within radius meters of a point, ordered by departure time. Needs the postgres driver.",
+			Params: params([]apiParam{
+				required(numberQuery("lat", "latitude of the center", -90, 90)),
+				required(numberQuery("lon", "longitude of the center", -180, 180)),
+				required(numberQuery("radius", "radius in meters", 0, ataxi.MaxSpatialRadius)),
+				intQuery("limit", fmt.Sprintf("number of taxis to return (default %d)", defaultTaxiLimit), 1, maxTaxiLimit),
+			}, taxiFilterParamSpecs),
+			Response: []ataxi.Taxi{},
+			Handler:  taxisWithinRadiusHandler,
+		},
+		{
+			Path:        "/api/spatial/taxis/polygon",
+			Summary:     "List taxis departing within a polygon",
+			Description: "Returns the taxis matching the filters departing within a GeoJSON Polygon or MultiPolygon in WGS 84 lon/lat, ordered by departure time. Needs the postgres driver.",
+			Params: params([]apiParam{
+				required(stringQuery("polygon", "GeoJSON geometry of the polygon")),
+				intQuery("limit", fmt.Sprintf("number of taxis to return (default %d)", defaultTaxiLimit), 1, maxTaxiLimit),
+			}, taxiFilterParamSpecs),
+			Response: []ataxi.Taxi{},
+			Handler:  taxisWithinPolygonHandler,
+		},
+		{
+			Path:        "/api/spatial/demand",
+			Summary:     "Demand per polygon",
+			Description: "Returns the number of taxis matching the filters departing from each polygon of a boundary layer, such as census tracts, read from static/boundaries/{layer}.geojson. Needs the postgres driver.",
+			Params: params([]apiParam{
+				required(stringQuery("layer", "name of the boundary layer, e.g. tracts")),
+			}, taxiFilterParamSpecs, []apiParam{formatParam}),
+			Response:  []ataxi.PolygonDemand{},
+			Formats:   true,
+			Cacheable: true,
+			Handler:   polygonDemandHandler,
+		},
+		{
+			Path:    "/api/passengers",
+			Summary: "List passengers",
+			Params: []apiParam{
+				intQuery("limit", fmt.Sprintf("number of passengers to return (default %d, at most %d for json)", defaultTaxiLimit, maxTaxiLimit), 1, math.MaxInt32),
+				uint32Query("taxi_id", "only return the passengers of this taxi"),
+				formatParam,
+			},
+			Response: []passengerResource{},
+			Formats:  true,
+			Streamed: true,
+			Handler:  listPassengersHandler,
+		},
+		{
+			Path:     "/api/passengers/{id:[0-9]+}",
+			Summary:  "Get a passenger",
+			Params:   []apiParam{idPath("id", "passenger id")},
+			Response: passengerResource{},
+			Handler:  passengerHandler,
+		},
+		{
+			Path:    "/api/fips",
+			Summary: "List states and counties",
+			Params: []apiParam{
+				uint32Query("state", "only list the counties of the state with this FIPS code"),
+			},
+			Response: fipsList{},
+			Handler:  listFIPSHandler,
+		},
+		{
+			Path:        "/api/fips/{fips:[0-9]+}",
+			Summary:     "Get a state or county",
+			Description: "Returns the state (two digit code) or county (five digit code) with the given FIPS code.",
+			Params:      []apiParam{idPath("fips", "state or county FIPS code")},
+			OneOf:       []interface{}{ataxi.State{}, ataxi.County{}},
+			Handler:     fipsHandler,
+		},
+		{
+			Path:    "/api/openapi.json",
+			Summary: "This OpenAPI document",
+			Handler: openAPIHandler,
+		},
+	}
+}
+
+// fieldError is the error of a single request parameter.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// rangeMessage describes the bounds of a numeric param, either of which may be
+// unset.
+func (p apiParam) rangeMessage() string {
+	switch {
+	case p.Minimum != nil && p.Maximum != nil:
+		return fmt.Sprintf("must be between %d and %d", *p.Minimum, *p.Maximum)
+	case p.Minimum != nil:
+		return fmt.Sprintf("must be at least %d", *p.Minimum)
+	}
+	return fmt.Sprintf("must be at most %d", *p.Maximum)
+}
+
+// validate checks a param value against its spec.
+func (p apiParam) validate(value string) string {
+	switch p.Type {
+	case "integer":
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Sprintf("must be an integer, got %q", value)
+		}
+		if (p.Minimum != nil && v < *p.Minimum) || (p.Maximum != nil && v > *p.Maximum) {
+			return p.rangeMessage()
+		}
+	case "number":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Sprintf("must be a number, got %q", value)
+		}
+		if (p.Minimum != nil && v < float64(*p.Minimum)) || (p.Maximum != nil && v > float64(*p.Maximum)) {
+			return p.rangeMessage()
+		}
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Sprintf("must be a boolean, got %q", value)
+		}
+	}
+	if len(p.Enum) > 0 {
+		for _, allowed := range p.Enum {
+			if value == allowed {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %s", strings.Join(p.Enum, ", "))
+	}
+	return ""
+}
+
+// validated checks the params of requests to route against its spec before
+// calling fn, responding with 400 and the errors of every invalid param.
+func validated(route apiRoute, fn appHandler) appHandler {
+	return func(w http.ResponseWriter, r *http.Request) *appError {
+		query := r.URL.Query()
+		vars := mux.Vars(r)
+		var errs []fieldError
+		for _, p := range route.Params {
+			var value string
+			var ok bool
+			if p.In == "path" {
+				value, ok = vars[p.Name]
+			} else if values, present := query[p.Name]; present {
+				value, ok = values[0], true
+			}
+			if !ok {
+				if p.Required {
+					errs = append(errs, fieldError{Field: p.Name, Message: "is required"})
+				}
+				continue
+			}
+			if msg := p.validate(value); msg != "" {
+				errs = append(errs, fieldError{Field: p.Name, Message: msg})
+			}
+		}
+		if len(errs) > 0 {
+			e := appErrorf(nil, 400, "invalid request parameters")
+			e.Fields = errs
+			return e
+		}
+		return fn(w, r)
+	}
+}
+
+var pathVarRegexp = regexp.MustCompile(`\{([a-z_]+)(:[^}]*)?\}`)
+
+// openAPIPath converts a mux path template to an OpenAPI path.
+func openAPIPath(path string) string {
+	return pathVarRegexp.ReplaceAllString(path, "{$1}")
+}
+
+// registerAPI registers the routes of the API on r.
+func registerAPI(r *mux.Router) {
+	for _, route := range apiRoutes {
+		handler := route.Handler
+		if route.Cacheable {
+			handler = cacheable(handler)
+		}
+		handler = validated(route, handler)
+		var h http.Handler = handler
+		if route.Streamed {
+			h = streamHandler(handler)
+		}
+		r.Methods("GET").Path(route.Path).Handler(h)
+	}
+}
+
+// checkAPI verifies that every route under /api registered on r is described
+// by apiRoutes, and that the path params of each route match its spec, so
+// that the OpenAPI document cannot drift from the server.
+func checkAPI(r *mux.Router) error {
+	described := make(map[string]apiRoute)
+	for _, route := range apiRoutes {
+		described[route.Path] = route
+		pathVars := make(map[string]bool)
+		for _, match := range pathVarRegexp.FindAllStringSubmatch(route.Path, -1) {
+			pathVars[match[1]] = true
+		}
+		for _, p := range route.Params {
+			if p.In == "path" && !pathVars[p.Name] {
+				return fmt.Errorf("openapi: %s describes path param %s missing from the path", route.Path, p.Name)
+			}
+			delete(pathVars, p.Name)
+		}
+		for name := range pathVars {
+			return fmt.Errorf("openapi: path param %s of %s is not described", name, route.Path)
+		}
+	}
+	return r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(path, "/api/") {
+			return nil
+		}
+		if _, ok := described[path]; !ok {
+			return fmt.Errorf("openapi: route %s is not described in apiRoutes", path)
+		}
+		return nil
+	})
+}
+
+// openAPIDocument returns the OpenAPI 3 document of the API.
+func openAPIDocument() map[string]interface{} {
+	schemas := make(map[string]interface{})
+	paths := make(map[string]interface{})
+	for _, route := range apiRoutes {
+		var parameters []interface{}
+		for _, p := range route.Params {
+			schema := map[string]interface{}{"type": p.Type}
+			if p.Minimum != nil {
+				schema["minimum"] = *p.Minimum
+			}
+			if p.Maximum != nil {
+				schema["maximum"] = *p.Maximum
+			}
+			if len(p.Enum) > 0 {
+				schema["enum"] = p.Enum
+			}
+			parameters = append(parameters, map[string]interface{}{
+				"name":        p.Name,
+				"in":          p.In,
+				"description": p.Description,
+				"required":    p.Required,
+				"schema":      schema,
+			})
+		}
+		schema := schemaOf(reflect.TypeOf(route.Response), schemas)
+		if len(route.OneOf) > 0 {
+			var alternatives []interface{}
+			for _, response := range route.OneOf {
+				alternatives = append(alternatives, schemaOf(reflect.TypeOf(response), schemas))
+			}
+			schema = map[string]interface{}{"oneOf": alternatives}
+		}
+		content := map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schema},
+		}
+		if route.Formats {
+			content["text/csv"] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+			content["application/x-ndjson"] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+		}
+		errorResponse := func(description string) interface{} {
+			return map[string]interface{}{
+				"description": description,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+					},
+				},
+			}
+		}
+		responses := map[string]interface{}{
+			"200":     map[string]interface{}{"description": "OK", "content": content},
+			"400":     errorResponse("Invalid request parameters"),
+			"default": errorResponse("Error"),
+		}
+		if route.Cacheable {
+			responses["304"] = map[string]interface{}{"description": "Not modified since the ETag in If-None-Match"}
+		}
+		operation := map[string]interface{}{
+			"summary":   route.Summary,
+			"responses": responses,
+		}
+		if route.Description != "" {
+			operation["description"] = route.Description
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		paths[openAPIPath(route.Path)] = map[string]interface{}{"get": operation}
+	}
+	schemas["Error"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"error": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"code":    map[string]interface{}{"type": "integer"},
+					"message": map[string]interface{}{"type": "string"},
+					"fields": map[string]interface{}{
+						"type": "array",
+						"items": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"field":   map[string]interface{}{"type": "string"},
+								"message": map[string]interface{}{"type": "string"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "aTaxi API",
+			"version": "1.0.0",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+// schemaOf returns the json schema of values of type t, as encoded by
+// encoding/json. Named structs are added to schemas and referenced.
+func schemaOf(t reflect.Type, schemas map[string]interface{}) interface{} {
+	if t == nil {
+		return map[string]interface{}{"type": "object"}
+	}
+	t = indirectType(t)
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		name := schemaName(t)
+		if name == "" {
+			// Anonymous structs have no component to reference.
+			properties := make(map[string]interface{})
+			structProperties(t, schemas, properties)
+			return map[string]interface{}{"type": "object", "properties": properties}
+		}
+		if _, ok := schemas[name]; !ok {
+			schemas[name] = nil // guards against recursive types
+			properties := make(map[string]interface{})
+			structProperties(t, schemas, properties)
+			schemas[name] = map[string]interface{}{"type": "object", "properties": properties}
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+// schemaName returns the component name of a struct type, e.g. Taxi or
+// TaxiResource, or "" for anonymous structs.
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	if name == "" {
+		return ""
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// structProperties adds the json properties of the fields of struct type t,
+// flattening embedded structs like encoding/json.
+func structProperties(t reflect.Type, schemas map[string]interface{}, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if embeddedStruct(field) {
+			structProperties(indirectType(field.Type), schemas, properties)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		properties[name] = schemaOf(field.Type, schemas)
+	}
+}
+
+// openAPIHandler returns the OpenAPI document of the API.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) *appError {
+	return writeJSON(w, openAPIDocument())
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// invalidValue returns a value p rejects, or "" if every value is valid.
+func invalidValue(p apiParam) string {
+	switch {
+	case p.In == "path":
+		// Path params only match digits, so only their range can be invalid.
+		return "99999999999"
+	case p.Type == "integer" || p.Type == "number":
+		return "x"
+	case p.Type == "boolean":
+		return "maybe"
+	case len(p.Enum) > 0:
+		return "not-an-option"
+	}
+	return ""
+}
+
+// requestPath returns the path of route with every path var set to 1, or to
+// value for the var named name.
+func requestPath(route apiRoute, name string, value string) string {
+	return pathVarRegexp.ReplaceAllStringFunc(route.Path, func(v string) string {
+		if pathVarRegexp.FindStringSubmatch(v)[1] == name {
+			return value
+		}
+		return "1"
+	})
+}
+
+func get(t *testing.T, r *mux.Router, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+	return rec
+}
+
+func fieldErrors(t *testing.T, rec *httptest.ResponseRecorder) map[string]string {
+	t.Helper()
+	var body struct {
+		Error errorBody `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("could not parse error body %q: %v", rec.Body.String(), err)
+	}
+	fields := make(map[string]string)
+	for _, field := range body.Error.Fields {
+		fields[field.Field] = field.Message
+	}
+	return fields
+}
+
+func TestEveryRouteIsDescribed(t *testing.T) {
+	r := newRouter("static")
+	if err := checkAPI(r); err != nil {
+		t.Fatal(err)
+	}
+
+	registered := make(map[string]bool)
+	r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if path, err := route.GetPathTemplate(); err == nil {
+			registered[path] = true
+		}
+		return nil
+	})
+	for _, route := range apiRoutes {
+		if !registered[route.Path] {
+			t.Errorf("%s is described but not registered", route.Path)
+		}
+	}
+
+	r.Methods("GET").Path("/api/undescribed").Handler(appHandler(healthzHandler))
+	if err := checkAPI(r); err == nil {
+		t.Error("checkAPI accepted a route missing from apiRoutes")
+	}
+}
+
+func TestStreamedRoutesHaveTheStreamTimeout(t *testing.T) {
+	streamed := make(map[string]bool)
+	for _, route := range apiRoutes {
+		streamed[route.Path] = route.Streamed
+	}
+	newRouter("static").Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(path, "/api/") {
+			return nil
+		}
+		if _, ok := route.GetHandler().(streamHandler); ok != streamed[path] {
+			t.Errorf("%s: got streamHandler %v, want %v", path, ok, streamed[path])
+		}
+		return nil
+	})
+}
+
+func TestEveryParamIsValidated(t *testing.T) {
+	r := newRouter("static")
+	for _, route := range apiRoutes {
+		for _, p := range route.Params {
+			value := invalidValue(p)
+			if value == "" {
+				continue
+			}
+			target := requestPath(route, p.Name, value)
+			if p.In == "query" {
+				target += "?" + url.Values{p.Name: {value}}.Encode()
+			}
+			rec := get(t, r, target)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: got status %d, want 400", target, rec.Code)
+				continue
+			}
+			if _, ok := fieldErrors(t, rec)[p.Name]; !ok {
+				t.Errorf("%s: no error for param %s", target, p.Name)
+			}
+		}
+	}
+}
+
+func TestRequiredParamsAreValidated(t *testing.T) {
+	r := newRouter("static")
+	for _, route := range apiRoutes {
+		var missing []string
+		for _, p := range route.Params {
+			if p.In == "query" && p.Required {
+				missing = append(missing, p.Name)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		target := requestPath(route, "", "")
+		rec := get(t, r, target)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want 400", target, rec.Code)
+			continue
+		}
+		fields := fieldErrors(t, rec)
+		for _, name := range missing {
+			if fields[name] != "is required" {
+				t.Errorf("%s: got %q for missing param %s, want \"is required\"", target, fields[name], name)
+			}
+		}
+	}
+}
+
+func TestParamBounds(t *testing.T) {
+	tests := []struct {
+		param apiParam
+		value string
+		want  string
+	}{
+		{apiParam{Type: "integer", Minimum: int64Ptr(1)}, "0", "must be at least 1"},
+		{apiParam{Type: "integer", Minimum: int64Ptr(1)}, "5", ""},
+		{apiParam{Type: "integer", Maximum: int64Ptr(10)}, "11", "must be at most 10"},
+		{apiParam{Type: "number", Maximum: int64Ptr(10)}, "10.5", "must be at most 10"},
+		{apiParam{Type: "number", Minimum: int64Ptr(-1), Maximum: int64Ptr(1)}, "-2", "must be between -1 and 1"},
+		{apiParam{Type: "integer"}, "-99", ""},
+	}
+	for _, test := range tests {
+		if got := test.param.validate(test.value); got != test.want {
+			t.Errorf("validate(%q) with bounds %v, %v: got %q, want %q",
+				test.value, test.param.Minimum, test.param.Maximum, got, test.want)
+		}
+	}
+}
+
+func TestSchemaOfAnonymousStruct(t *testing.T) {
+	schemas := make(map[string]interface{})
+	schema := schemaOf(reflect.TypeOf(struct{ Count int }{}), schemas)
+	want := map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"Count": map[string]interface{}{"type": "integer"}},
+	}
+	if !reflect.DeepEqual(schema, want) {
+		t.Errorf("got %v, want %v", schema, want)
+	}
+}
+
+// refs returns the component references of a decoded json document.
+func refs(v interface{}) []string {
+	var found []string
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if ref, ok := value.(string); ok && key == "$ref" {
+				found = append(found, ref)
+			}
+			found = append(found, refs(value)...)
+		}
+	case []interface{}:
+		for _, value := range v {
+			found = append(found, refs(value)...)
+		}
+	}
+	return found
+}
+
+func TestOpenAPIDocumentRoundTrips(t *testing.T) {
+	rec := get(t, newRouter("static"), "/api/openapi.json")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d", rec.Code)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again map[string]interface{}
+	if err := json.Unmarshal(encoded, &again); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(doc, again) {
+		t.Error("the document changed when encoded again")
+	}
+
+	paths := doc["paths"].(map[string]interface{})
+	for _, route := range apiRoutes {
+		path, ok := paths[openAPIPath(route.Path)].(map[string]interface{})
+		if !ok {
+			t.Errorf("%s is missing from the document", route.Path)
+			continue
+		}
+		operation := path["get"].(map[string]interface{})
+		documented := make(map[string]bool)
+		parameters, _ := operation["parameters"].([]interface{})
+		for _, parameter := range parameters {
+			documented[parameter.(map[string]interface{})["name"].(string)] = true
+		}
+		for _, p := range route.Params {
+			if !documented[p.Name] {
+				t.Errorf("%s: param %s is missing from the document", route.Path, p.Name)
+			}
+		}
+	}
+
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	for _, ref := range refs(doc) {
+		if _, ok := schemas[strings.TrimPrefix(ref, "#/components/schemas/")]; !ok {
+			t.Errorf("%s does not resolve", ref)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/webapps/ataxi"
+)
+
+var playbackTmpl *appTemplate
+
+// playbackPageHandler displays the fleet playback page.
+func playbackPageHandler(w http.ResponseWriter, r *http.Request) *appError {
+	return playbackTmpl.Execute(w, r, nil)
+}
+
+// fleetTrip is the trip of a taxi as played back by the fleet playback page:
+// where and when it departs and is made empty, and its occupancy.
+type fleetTrip struct {
+	ID            uint
+	DepartureTime uint32
+	MadeEmptyTime uint32
+	OX            int32
+	OY            int32
+	OLat          float64
+	OLon          float64
+	DX            int32
+	DY            int32
+	DLat          float64
+	DLon          float64
+	NumPassengers uint32
+	MaxOccupancy  uint32
+}
+
+func newFleetTrip(taxi *ataxi.Taxi) fleetTrip {
+	return fleetTrip{
+		ID:            taxi.ID,
+		DepartureTime: taxi.DepartureTime,
+		MadeEmptyTime: uint32(taxi.MadeEmptyTime()),
+		OX:            taxi.OX,
+		OY:            taxi.OY,
+		OLat:          taxi.OLat,
+		OLon:          taxi.OLon,
+		DX:            taxi.DX,
+		DY:            taxi.DY,
+		DLat:          taxi.DLat,
+		DLon:          taxi.DLon,
+		NumPassengers: taxi.NumPassengers,
+		MaxOccupancy:  taxi.MaxOccupancy,
+	}
+}
+
+// playbackHandler returns the trips of the taxis matching the request
+// filters in departure time order, as json or streamed as csv or ndjson.
+func playbackHandler(w http.ResponseWriter, r *http.Request) *appError {
+	format, e := negotiateFormat(r)
+	if e != nil {
+		return e
+	}
+	params := r.URL.Query()
+	limit, e := limitParam(params, format != formatJSON)
+	if e != nil {
+		return e
+	}
+	filter, e := taxiFilterParams(params)
+	if e != nil {
+		return e
+	}
+
+	if format == formatJSON {
+		taxis, err := ataxi.DB.QueryTaxis(r.Context(), filter, "departure_time", 0, limit, false)
+		if err != nil {
+			return dbError(r.Context(), err, "list trips")
+		}
+		trips := make([]fleetTrip, len(taxis))
+		for i := range taxis {
+			trips[i] = newFleetTrip(&taxis[i])
+		}
+		return writeJSON(w, trips)
+	}
+	rw := newRowWriter(w, format, fleetTrip{})
+	err := ataxi.DB.StreamTaxis(r.Context(), filter, "departure_time", 0, limit, false, func(taxi *ataxi.Taxi) error {
+		return rw.Write(newFleetTrip(taxi))
+	})
+	if err == nil {
+		err = rw.Close()
+	}
+	if err != nil {
+		return streamError(rw, dbError(r.Context(), err, "list trips"))
+	}
+	return nil
+}
+
+// activeTaxisHandler returns the number of taxis matching the request
+// filters that are on the road in every minute of the simulation, counted as
+// by the active_taxis script.
+func activeTaxisHandler(w http.ResponseWriter, r *http.Request) *appError {
+	format, e := negotiateFormat(r)
+	if e != nil {
+		return e
+	}
+	filter, e := taxiFilterParams(r.URL.Query())
+	if e != nil {
+		return e
+	}
+	var active ataxi.ActiveTaxis
+	err := ataxi.DB.StreamTaxis(r.Context(), filter, "departure_time", 0, 0, false, func(taxi *ataxi.Taxi) error {
+		active.AddTaxi(taxi)
+		return nil
+	})
+	if err != nil {
+		return dbError(r.Context(), err, "count active taxis")
+	}
+	return writeFormat(w, format, active.Counts())
+}
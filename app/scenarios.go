@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/webapps/ataxi"
+)
+
+var scenariosTmpl *appTemplate
+
+// scenariosPageHandler displays the scenario comparison page.
+func scenariosPageHandler(w http.ResponseWriter, r *http.Request) *appError {
+	return scenariosTmpl.Execute(w, r, nil)
+}
+
+// listScenariosHandler returns the scenarios of the stored taxis.
+func listScenariosHandler(w http.ResponseWriter, r *http.Request) *appError {
+	scenarios, err := ataxi.DB.ListScenarios(r.Context())
+	if err != nil {
+		return dbError(r.Context(), err, "list scenarios")
+	}
+	return writeJSON(w, scenarios)
+}
+
+// scenarioStats returns the AVO statistics and supply and demand of a
+// scenario, grouped by county or hour.
+func scenarioStats(ctx context.Context, scenario string, groupBy string) ([]ataxi.AVOStats, []ataxi.GroupSupplyDemand, *appError) {
+	filter := ataxi.TaxiFilter{Scenario: &scenario}
+	avo, err := ataxi.DB.GetAVOStats(ctx, groupBy, filter)
+	if err != nil {
+		return nil, nil, dbError(ctx, err, "compute AVO statistics of scenario "+scenario)
+	}
+	supplyDemand, err := ataxi.DB.GetSupplyDemandByGroup(ctx, groupBy, filter)
+	if err != nil {
+		return nil, nil, dbError(ctx, err, "compute supply and demand of scenario "+scenario)
+	}
+	return avo, supplyDemand, nil
+}
+
+// compareScenariosHandler returns the fleet size, AVO and supply and demand
+// of two scenarios and their difference, by county or hour.
+func compareScenariosHandler(w http.ResponseWriter, r *http.Request) *appError {
+	params := r.URL.Query()
+	groupBy := "county"
+	if groupParam := params.Get("group_by"); groupParam != "" {
+		groupBy = groupParam
+	}
+	avoA, supplyDemandA, e := scenarioStats(r.Context(), params.Get("a"), groupBy)
+	if e != nil {
+		return e
+	}
+	avoB, supplyDemandB, e := scenarioStats(r.Context(), params.Get("b"), groupBy)
+	if e != nil {
+		return e
+	}
+	comparison := ataxi.CompareScenarios(avoA, supplyDemandA, avoB, supplyDemandB)
+	if groupBy == "county" {
+		// Counties only taxis arrive in have no AVO statistics to name them.
+		for i := range comparison {
+			if fips, err := strconv.ParseUint(comparison[i].Key, 10, 32); err == nil && comparison[i].Name == "" {
+				county, _ := ataxi.GetCounty(uint32(fips))
+				comparison[i].Name = county.Name
+			}
+		}
+	}
+	return writeJSON(w, comparison)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/webapps/ataxi"
+)
+
+// layerRegexp matches the names of the boundary layers in static/boundaries.
+var layerRegexp = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// floatParam parses the named query param, which has been validated as a
+// number.
+func floatParam(r *http.Request, name string) float64 {
+	value, _ := strconv.ParseFloat(r.URL.Query().Get(name), 64)
+	return value
+}
+
+// taxisWithinRadiusHandler returns the taxis matching the request filters
+// departing within the radius param, in meters, of the lat and lon params.
+func taxisWithinRadiusHandler(w http.ResponseWriter, r *http.Request) *appError {
+	params := r.URL.Query()
+	limit, e := limitParam(params, false)
+	if e != nil {
+		return e
+	}
+	filter, e := taxiFilterParams(params)
+	if e != nil {
+		return e
+	}
+	taxis, err := ataxi.DB.ListTaxisWithinRadius(r.Context(), floatParam(r, "lat"), floatParam(r, "lon"), floatParam(r, "radius"),
+		filter, limit)
+	if err != nil {
+		return dbError(r.Context(), err, "list taxis within radius")
+	}
+	return writeJSON(w, taxis)
+}
+
+// taxisWithinPolygonHandler returns the taxis matching the request filters
+// departing within the GeoJSON polygon param.
+func taxisWithinPolygonHandler(w http.ResponseWriter, r *http.Request) *appError {
+	params := r.URL.Query()
+	polygon := params.Get("polygon")
+	if err := ataxi.ValidatePolygon(polygon); err != nil {
+		return appErrorf(err, 400, "invalid polygon param: %v", err)
+	}
+	limit, e := limitParam(params, false)
+	if e != nil {
+		return e
+	}
+	filter, e := taxiFilterParams(params)
+	if e != nil {
+		return e
+	}
+	taxis, err := ataxi.DB.ListTaxisWithinPolygon(r.Context(), polygon, filter, limit)
+	if err != nil {
+		return dbError(r.Context(), err, "list taxis within polygon")
+	}
+	return writeJSON(w, taxis)
+}
+
+// polygonDemandHandler returns the number of taxis matching the request
+// filters departing from each polygon of the boundary layer param, read from
+// static/boundaries/<layer>.geojson.
+func polygonDemandHandler(w http.ResponseWriter, r *http.Request) *appError {
+	format, e := negotiateFormat(r)
+	if e != nil {
+		return e
+	}
+	params := r.URL.Query()
+	layer := params.Get("layer")
+	if !layerRegexp.MatchString(layer) {
+		return appErrorf(nil, 400, "invalid layer: \"%s\"", layer)
+	}
+	filter, e := taxiFilterParams(params)
+	if e != nil {
+		return e
+	}
+	polygons, err := ataxi.ReadPolygons(filepath.Join(ataxi.Config.StaticDir, "boundaries", layer+".geojson"))
+	if os.IsNotExist(err) {
+		return appErrorf(err, 404, "no boundaries for layer %s, see static/boundaries/README.md", layer)
+	} else if err != nil {
+		return appErrorf(err, 500, "could not read boundaries of layer %s: %v", layer, err)
+	}
+	demand, err := ataxi.DB.GetDemandForPolygons(r.Context(), polygons, filter)
+	if err != nil {
+		return dbError(r.Context(), err, "compute demand for polygons")
+	}
+	return writeFormat(w, format, demand)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/webapps/ataxi"
+)
+
+// summariesError maps the error of reading the summaries of a run to a
+// response.
+func summariesError(ctx context.Context, err error, runID uint32) *appError {
+	if errors.Is(err, ataxi.ErrNotFound) && runID == 0 {
+		return appErrorf(err, 404, "no runs, run region_avo or db_populate first")
+	} else if errors.Is(err, ataxi.ErrNotFound) {
+		return appErrorf(err, 404, "no run with id %d", runID)
+	}
+	return dbError(ctx, err, "get summaries")
+}
+
+// runParam returns the run param, or 0 for the latest run.
+func runParam(r *http.Request) (uint32, *appError) {
+	var run *uint32
+	if e := uint32Param(r.URL.Query(), "run", &run); e != nil {
+		return 0, e
+	}
+	if run == nil {
+		return 0, nil
+	}
+	return *run, nil
+}
+
+// listRunsHandler returns the stored runs of region_avo and db_populate.
+func listRunsHandler(w http.ResponseWriter, r *http.Request) *appError {
+	runs, err := ataxi.DB.ListRuns(r.Context())
+	if err != nil {
+		return dbError(r.Context(), err, "list runs")
+	}
+	return writeJSON(w, runs)
+}
+
+// stateSummariesHandler returns the state summaries of the run param.
+func stateSummariesHandler(w http.ResponseWriter, r *http.Request) *appError {
+	format, e := negotiateFormat(r)
+	if e != nil {
+		return e
+	}
+	runID, e := runParam(r)
+	if e != nil {
+		return e
+	}
+	states, err := ataxi.DB.GetStateSummaries(r.Context(), uint(runID))
+	if err != nil {
+		return summariesError(r.Context(), err, runID)
+	}
+	return writeFormat(w, format, states)
+}
+
+// countySummariesHandler returns the county summaries of the run param, or of
+// the counties of the state param.
+func countySummariesHandler(w http.ResponseWriter, r *http.Request) *appError {
+	format, e := negotiateFormat(r)
+	if e != nil {
+		return e
+	}
+	runID, e := runParam(r)
+	if e != nil {
+		return e
+	}
+	var state *uint32
+	if e := uint32Param(r.URL.Query(), "state", &state); e != nil {
+		return e
+	}
+	counties, err := ataxi.DB.GetCountySummaries(r.Context(), uint(runID))
+	if err != nil {
+		return summariesError(r.Context(), err, runID)
+	}
+	if state != nil {
+		var inState []ataxi.CountySummary
+		for _, county := range counties {
+			if county.StateFIPS == *state {
+				inState = append(inState, county)
+			}
+		}
+		counties = inState
+	}
+	return writeFormat(w, format, counties)
+}
@@ -10,12 +10,12 @@ import (
 	"github.com/webapps/ataxi"
 )
 
-// parseTemplate applies a given file to the body of the base template.
-func parseTemplate(filename string) *appTemplate {
-	tmpl := template.Must(template.ParseFiles("templates/base.html"))
+// parseTemplate applies a given file in dir to the body of the base template.
+func parseTemplate(dir string, filename string) *appTemplate {
+	tmpl := template.Must(template.ParseFiles(filepath.Join(dir, "base.html")))
 
 	// Put the named file into a template called "body"
-	path := filepath.Join("templates", filename)
+	path := filepath.Join(dir, filename)
 	b, err := ioutil.ReadFile(path)
 	if err != nil {
 		panic(fmt.Errorf("could not read template: %v", err))
@@ -41,7 +41,7 @@ func (tmpl *appTemplate) Execute(w http.ResponseWriter, r *http.Request, data in
 		Config: ataxi.Config,
 	}
 	if err := tmpl.t.Execute(w, d); err != nil {
-		return appErrorf(err, 500, "could not write template: %v")
+		return appErrorf(err, 500, "could not write template: %v", err)
 	}
 	return nil
 }
@@ -3,17 +3,17 @@ package main
 import (
 	"bufio"
 	"encoding/csv"
-	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
-	"math"
 	"os"
 	"path/filepath"
-	"regexp"
+	"sort"
 	"strconv"
 	"time"
 
+	"github.com/jinzhu/gorm"
 	"github.com/webapps/ataxi"
 )
 
@@ -38,10 +38,10 @@ func handlePassenger(taxis []*ataxi.Taxi, potentialTaxis []*ataxi.Taxi,
 		taxi = ataxi.NewTaxi(uint(len(taxis)+1), passenger, maxOccupancy)
 		taxis = append(taxis, taxi)
 		if newTaxiStand {
-            for _, taxi := range potentialTaxis {
-                taxi.PMT = taxi.PersonMilesTraveled()
-                taxi.VMT = taxi.VehicleMilesTraveled()
-            }
+			for _, taxi := range potentialTaxis {
+				taxi.PMT = taxi.PersonMilesTraveled()
+				taxi.VMT = taxi.VehicleMilesTraveled()
+			}
 			potentialTaxis = []*ataxi.Taxi{taxi}
 		} else {
 			potentialTaxis = append(potentialTaxis, taxi)
@@ -62,34 +62,78 @@ func getMT(taxis []*ataxi.Taxi) (float64, float64) {
 	return pmt, vmt
 }
 
+// milesTraveled accumulates the person and vehicle miles of a county, state
+// or region.
+type milesTraveled struct {
+	PMT float64
+	VMT float64
+}
+
+func (mt *milesTraveled) add(pmt float64, vmt float64) {
+	mt.PMT += pmt
+	mt.VMT += vmt
+}
+
+// row returns the key columns followed by the AVO, PMT and VMT columns.
+func (mt *milesTraveled) row(keys ...string) []string {
+	return append(keys,
+		strconv.FormatFloat(mt.PMT/mt.VMT, 'f', 2, 64),
+		strconv.FormatFloat(mt.PMT, 'f', 2, 64),
+		strconv.FormatFloat(mt.VMT, 'f', 2, 64),
+	)
+}
+
 func main() {
-	if len(os.Args) != 2 {
-		log.Fatal(errors.New("You must provide a data directory containing the ataxi mode trip files."))
-		os.Exit(1)
+	regionsFlag := flag.String("regions", "census-region",
+		"region grouping: census-region, census-division or a csv file of FIPS,Region rows")
+	metricsFile := flag.String("metrics-file", "",
+		"write per county metrics in the Prometheus text format to this file")
+	pushgateway := flag.String("pushgateway", "", "push per county metrics to this Prometheus Pushgateway URL")
+	saveRun := flag.Bool("db", false, "save the county and state summaries to the database of config.json")
+	scenario := flag.String("scenario", ataxi.DefaultScenario, "scenario the saved summaries are recorded under")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatal("You must provide a data directory containing the ataxi mode trip files.")
 	}
 
-	files, err := filepath.Glob(filepath.Join(os.Args[1], "*.csv"))
+	grouping, err := ataxi.GetRegionGrouping(*regionsFlag)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	stateFile, err := os.Create("../data/state_avos.csv")
+	files, err := filepath.Glob(filepath.Join(flag.Arg(0), "*.csv"))
 	if err != nil {
 		log.Fatal(err)
 	}
-	stateWriter := csv.NewWriter(stateFile)
-	stateColumns := []string{"State", "AVO", "PMT", "VMT"}
-	stateWriter.Write(stateColumns)
-	var stateRow [4]string
+
+	// Connect before reading the trip files, rather than failing after.
+	var db *gorm.DB
+	if *saveRun {
+		config, err := ataxi.LoadConfig("")
+		if err != nil {
+			log.Fatal(err)
+		}
+		db, err = gorm.Open(config.DBDriver, config.DataSourceName())
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+		schemaVersion, err := ataxi.AppliedSchemaVersion(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := ataxi.CheckSchemaVersion(schemaVersion); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	countyFile, err := os.Create("../data/county_avos.csv")
 	if err != nil {
 		log.Fatal(err)
 	}
 	countyWriter := csv.NewWriter(countyFile)
-	countyColumns := []string{"County", "AVO", "PMT", "VMT"}
+	countyColumns := []string{"County", "CountyName", "StateAbbr", "StateName", "AVO", "PMT", "VMT"}
 	countyWriter.Write(countyColumns)
-	var countyRow [4]string
 
 	tripFile, err := os.Create("../data/ataxi_trips.csv")
 	if err != nil {
@@ -99,41 +143,33 @@ func main() {
 	tripColumns := []string{"OX", "OY", "DepartureTime", "DX", "DY",
 		"MadeEmptyTime", "VehicleTripMiles", "DepartureOccupancy",
 		"OccupantTripMiles", "OXSuper5", "OYSuper5", "DXSuper5", "DYSuper5",
-		"OXSuper10", "OYSuper10", "DXSuper10", "DYSuper10"}
+		"OXSuper10", "OYSuper10", "DXSuper10", "DYSuper10", "OFIPS",
+		"TripCategory", "OCountyName", "OStateAbbr", "OStateName"}
 	tripWriter.Write(tripColumns)
-	var tripRow [17]string
+	var tripRow [22]string
 
 	start := time.Now()
 	fmt.Println("Reading mode ataxi trip files...")
 
-	var regionPMT float64
-	var regionVMT float64
-	var statePMT float64
-	var stateVMT float64
+	var counties []ataxi.CountySummary
+	states := make(map[uint32]*milesTraveled)
+	regions := make(map[string]*milesTraveled)
 
-	var stateFIPS string
-	re := regexp.MustCompile("[0-9]+")
+	metrics := ataxi.NewBatchMetrics("region_avo")
 	var id uint
-	for i, file := range files {
+	for _, file := range files {
 		var countyTaxis []*ataxi.Taxi
 		var potentialTaxis []*ataxi.Taxi
+		countyStart := time.Now()
+		var rows int
 
 		_, filename := filepath.Split(file)
 		fmt.Printf("Processing %s\n", filename)
-		curFIPS := re.FindAllString(filename, 1)[0][:2]
-		if stateFIPS != curFIPS && i != 0 {
-			stateRow[0] = stateFIPS
-			stateRow[1] = strconv.FormatFloat(statePMT/stateVMT, 'f', 2, 64)
-            stateRow[2] = strconv.FormatFloat(statePMT, 'f', 2, 64)
-            stateRow[3] = strconv.FormatFloat(stateVMT, 'f', 2, 64)
-			stateWriter.Write(stateRow[:])
-			fmt.Printf("state %s avo: %s - pmt: %s - vmt: %s\n", stateRow[0], stateRow[1], stateRow[2], stateRow[3])
-			statePMT = 0
-			stateVMT = 0
-		}
-		stateFIPS = curFIPS
-
-		csvFile, _ := os.Open(file)
+
+		csvFile, err := os.Open(file)
+		if err != nil {
+			log.Fatal(err)
+		}
 		reader := csv.NewReader(bufio.NewReader(csvFile))
 		if _, err := reader.Read(); err != nil {
 			log.Fatal(err)
@@ -145,6 +181,7 @@ func main() {
 			} else if err != nil {
 				log.Fatal(err)
 			}
+			rows++
 			row := ataxi.ParseLine(line)
 			passenger := ataxi.NewPassengerFromRow(id+1, row)
 			if passenger.TripCategory == 0 {
@@ -154,25 +191,42 @@ func main() {
 
 			countyTaxis, potentialTaxis = handlePassenger(countyTaxis, potentialTaxis, passenger, 5)
 		}
-	    for _, taxi := range potentialTaxis {
-            taxi.PMT = taxi.PersonMilesTraveled()
+		csvFile.Close()
+		for _, taxi := range potentialTaxis {
+			taxi.PMT = taxi.PersonMilesTraveled()
 			taxi.VMT = taxi.VehicleMilesTraveled()
 		}
-	    pmt, vmt := getMT(countyTaxis)
-		countyRow[0] = strconv.Itoa(int(countyTaxis[0].OFIPS))
-		countyRow[1] = strconv.FormatFloat(pmt/vmt, 'f', 2, 64)
-        countyRow[2] = strconv.FormatFloat(pmt, 'f', 2, 64)
-        countyRow[3] = strconv.FormatFloat(vmt, 'f', 2, 64)
-		countyWriter.Write(countyRow[:])
-		fmt.Printf("county avo: %s - pmt: %s - vmt: %s\n", countyRow[1], countyRow[2], countyRow[3])
+
+		countyFIPS, _ := ataxi.FIPSFromFilename(filename)
+		if len(countyTaxis) > 0 && countyTaxis[0].OFIPS != 0 {
+			countyFIPS = countyTaxis[0].OFIPS
+		}
+		metrics.ObserveCounty(countyFIPS, rows, len(countyTaxis), time.Since(countyStart))
+		if len(countyTaxis) == 0 {
+			fmt.Printf("No ataxi trips in %s\n", filename)
+			continue
+		}
+		var county milesTraveled
+		county.add(getMT(countyTaxis))
+		countyRow := county.row(append([]string{ataxi.FormatCountyFIPS(countyFIPS)},
+			ataxi.CountyColumns(countyFIPS)...)...)
+		countyWriter.Write(countyRow)
+		fmt.Printf("county %s avo: %s - pmt: %s - vmt: %s\n", countyRow[1], countyRow[4], countyRow[5], countyRow[6])
+		summary := ataxi.CountySummary{
+			FIPS:     countyFIPS,
+			Rows:     rows,
+			NumTaxis: len(countyTaxis),
+			PMT:      county.PMT,
+			VMT:      county.VMT,
+		}
 
 		for _, taxi := range countyTaxis {
-            tripRow[0] = strconv.Itoa(int(taxi.OX))
+			tripRow[0] = strconv.Itoa(int(taxi.OX))
 			tripRow[1] = strconv.Itoa(int(taxi.OY))
-			tripRow[2] = strconv.Itoa(int(taxi.DepartureTime) % 86400)
+			tripRow[2] = strconv.Itoa(int(taxi.DepartureTime))
 			tripRow[3] = strconv.Itoa(int(taxi.DX))
 			tripRow[4] = strconv.Itoa(int(taxi.DY))
-			tripRow[5] = strconv.Itoa((int(taxi.DepartureTime) + int(math.Ceil(taxi.VMT/30*3600))) % 86400)
+			tripRow[5] = strconv.Itoa(int(taxi.MadeEmptyTime()))
 			tripRow[6] = strconv.FormatFloat(taxi.VMT, 'f', 2, 64)
 			tripRow[7] = strconv.Itoa(int(taxi.NumPassengers))
 			tripRow[8] = strconv.FormatFloat(taxi.PMT, 'f', 2, 64)
@@ -188,31 +242,53 @@ func main() {
 			tripRow[14] = strconv.Itoa(int(oYSuper10))
 			tripRow[15] = strconv.Itoa(int(dXSuper10))
 			tripRow[16] = strconv.Itoa(int(dYSuper10))
+			tripRow[17] = ataxi.FormatCountyFIPS(taxi.OFIPS)
+			tripRow[18] = strconv.Itoa(int(taxi.Passengers[0].TripCategory))
+			copy(tripRow[19:], ataxi.CountyColumns(taxi.OFIPS))
 			tripWriter.Write(tripRow[:])
+			summary.NumPassengers += int(taxi.NumPassengers)
 		}
+		counties = append(counties, summary)
 
-		regionPMT += pmt
-		regionVMT += vmt
-		statePMT += pmt
-		stateVMT += vmt
+		stateFIPS := ataxi.StateFIPS(countyFIPS)
+		if _, ok := states[stateFIPS]; !ok {
+			states[stateFIPS] = &milesTraveled{}
+		}
+		states[stateFIPS].add(county.PMT, county.VMT)
+		region := grouping.Region(countyFIPS)
+		if _, ok := regions[region]; !ok {
+			regions[region] = &milesTraveled{}
+		}
+		regions[region].add(county.PMT, county.VMT)
 	}
 
-	stateRow[0] = stateFIPS
-	stateRow[1] = strconv.FormatFloat(statePMT/stateVMT, 'f', 2, 64)
-    stateRow[2] = strconv.FormatFloat(statePMT, 'f', 2, 64)
-    stateRow[3] = strconv.FormatFloat(stateVMT, 'f', 2, 64)
-    fmt.Printf("state %s avo: %s - pmt: %s - vmt: %s\n", stateRow[0], stateRow[1], stateRow[2], stateRow[3])
-	stateWriter.Write(stateRow[:])
-
-	stateWriter.Flush()
-	stateFile.Close()
-
 	countyWriter.Flush()
 	countyFile.Close()
 
 	tripWriter.Flush()
 	tripFile.Close()
 
+	stateFile, err := os.Create("../data/state_avos.csv")
+	if err != nil {
+		log.Fatal(err)
+	}
+	stateWriter := csv.NewWriter(stateFile)
+	stateColumns := []string{"State", "StateAbbr", "StateName", "AVO", "PMT", "VMT"}
+	stateWriter.Write(stateColumns)
+	var stateFIPSs []uint32
+	for stateFIPS := range states {
+		stateFIPSs = append(stateFIPSs, stateFIPS)
+	}
+	sort.Slice(stateFIPSs, func(i, j int) bool { return stateFIPSs[i] < stateFIPSs[j] })
+	for _, stateFIPS := range stateFIPSs {
+		stateRow := states[stateFIPS].row(append([]string{ataxi.FormatStateFIPS(stateFIPS)},
+			ataxi.StateColumns(stateFIPS)...)...)
+		stateWriter.Write(stateRow)
+		fmt.Printf("state %s avo: %s - pmt: %s - vmt: %s\n", stateRow[0], stateRow[3], stateRow[4], stateRow[5])
+	}
+	stateWriter.Flush()
+	stateFile.Close()
+
 	regionFile, err := os.Create("../data/region_avo.csv")
 	if err != nil {
 		log.Fatal(err)
@@ -220,16 +296,32 @@ func main() {
 	regionWriter := csv.NewWriter(regionFile)
 	regionColumns := []string{"Region", "AVO", "PMT", "VMT"}
 	regionWriter.Write(regionColumns)
-    regionAVO := regionPMT / regionVMT
-	regionAVOString := strconv.FormatFloat(regionAVO, 'f', 2, 64)
-    regionPMTString := strconv.FormatFloat(regionPMT, 'f', 2, 64)
-    regionVMTString := strconv.FormatFloat(regionVMT, 'f', 2, 64)
-	regionWriter.Write([]string{"South", regionAVOString, regionPMTString, regionVMTString})
-	fmt.Printf("region avo: %s - pmt: %s - vmt: %s\n", regionAVOString, regionPMTString, regionVMTString)
+	var regionNames []string
+	for region := range regions {
+		regionNames = append(regionNames, region)
+	}
+	sort.Strings(regionNames)
+	for _, region := range regionNames {
+		regionRow := regions[region].row(region)
+		regionWriter.Write(regionRow)
+		fmt.Printf("region %s avo: %s - pmt: %s - vmt: %s\n", regionRow[0], regionRow[1], regionRow[2], regionRow[3])
+	}
 
 	regionWriter.Flush()
 	regionFile.Close()
 
 	elapsed := time.Since(start)
 	fmt.Printf("csv processing took %s\n", elapsed)
+
+	if db != nil {
+		run := &ataxi.Run{Command: "region_avo", Scenario: *scenario, Source: flag.Arg(0)}
+		if err := ataxi.SaveRun(db, run, counties); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Saved the county and state summaries as run %d\n", run.ID)
+	}
+
+	if err := metrics.Dump(*metricsFile, *pushgateway); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,178 @@
+package ataxi
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// maxPlaceholders is the largest number of bind parameters MySQL and
+// PostgreSQL accept in a single statement.
+const maxPlaceholders = 65535
+
+// InsertTaxis inserts taxis and their passengers with multi-row inserts,
+// instead of the row by row inserts of gorm's Create. The taxis must have
+// their ids set; their passengers are linked to them by id. Run it inside a
+// transaction so that a failure leaves no taxi without its passengers.
+// Passengers without a scenario get their taxi's, and taxis without one
+// DefaultScenario, as the column default is bypassed.
+func InsertTaxis(tx *gorm.DB, taxis []*Taxi) error {
+	now := time.Now()
+	var taxiRows, passengerRows []interface{}
+	for _, taxi := range taxis {
+		taxi.CreatedAt = now
+		if taxi.Scenario == "" {
+			taxi.Scenario = DefaultScenario
+		}
+		taxiRows = append(taxiRows, taxi)
+		for i := range taxi.Passengers {
+			passenger := &taxi.Passengers[i]
+			passenger.TaxiID = taxi.ID
+			passenger.CreatedAt = now
+			if passenger.Scenario == "" {
+				passenger.Scenario = taxi.Scenario
+			}
+			passengerRows = append(passengerRows, passenger)
+		}
+	}
+	if err := insertRows(tx, taxiRows); err != nil {
+		return fmt.Errorf("could not insert taxis: %v", err)
+	}
+	if err := insertRows(tx, passengerRows); err != nil {
+		return fmt.Errorf("could not insert passengers: %v", err)
+	}
+	return nil
+}
+
+// insertRows inserts rows of the same model with as few statements as the
+// bind parameter limit allows. The columns are the model's fields as mapped by
+// gorm, so they follow the same tags as AutoMigrate. The primary key is left
+// to the database when the first row has none.
+func insertRows(tx *gorm.DB, rows []interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	scope := tx.NewScope(rows[0])
+	var columns []string
+	inserted := make(map[string]bool)
+	for _, field := range scope.Fields() {
+		if field.IsNormal && !field.IsIgnored && !(field.IsPrimaryKey && field.IsBlank) {
+			columns = append(columns, scope.Quote(field.DBName))
+			inserted[field.DBName] = true
+		}
+	}
+	placeholder := "(" + strings.TrimSuffix(strings.Repeat("?,", len(columns)), ",") + ")"
+	rowsPerStatement := maxPlaceholders / len(columns)
+
+	for start := 0; start < len(rows); start += rowsPerStatement {
+		end := start + rowsPerStatement
+		if end > len(rows) {
+			end = len(rows)
+		}
+		placeholders := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*len(columns))
+		for _, row := range rows[start:end] {
+			placeholders = append(placeholders, placeholder)
+			for _, field := range tx.NewScope(row).Fields() {
+				if inserted[field.DBName] {
+					args = append(args, field.Field.Interface())
+				}
+			}
+		}
+		sql := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", scope.QuotedTableName(),
+			strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+		if err := tx.Exec(sql, args...).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// idCounter is the next id InsertTaxis callers may use in a table.
+type idCounter struct {
+	Name   string `gorm:"primary_key" sql:"size:64"`
+	NextID uint
+}
+
+func (idCounter) TableName() string { return "id_counters" }
+
+// ReserveIDs reserves n consecutive ids of the taxis or passengers table and
+// returns the first. The counter row is locked while it is advanced, so that
+// concurrent loads never hand out the same ids, and it never falls behind ids
+// inserted without it. On PostgreSQL, the id sequence is moved past the
+// reserved ids too, so that rows inserted without an id do not collide.
+func ReserveIDs(db *gorm.DB, table string, n int) (uint, error) {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	var counter idCounter
+	err := tx.Set("gorm:query_option", "FOR UPDATE").Where("name = ?", table).First(&counter).Error
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("could not lock the id counter of %s: %v", table, err)
+	}
+	var maxID uint
+	if err := tx.Table(table).Select("COALESCE(MAX(id), 0)").Row().Scan(&maxID); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("could not read the largest id of %s: %v", table, err)
+	}
+	first := counter.NextID
+	if first <= maxID {
+		first = maxID + 1
+	}
+	last := first + uint(n) - 1
+	if err := tx.Model(&counter).Update("next_id", last+1).Error; err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("could not reserve ids of %s: %v", table, err)
+	}
+	if n > 0 && tx.Dialect().GetName() == "postgres" {
+		if err := tx.Exec("SELECT setval(pg_get_serial_sequence(?, 'id'), ?)", table, last).Error; err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("could not advance the id sequence of %s: %v", table, err)
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return 0, err
+	}
+	return first, nil
+}
+
+// BumpDatasetVersion advances the dataset version, so that the server drops
+// the results it cached for the previous one.
+func BumpDatasetVersion(db *gorm.DB) error {
+	err := db.Exec("UPDATE dataset_versions SET version = version + 1, updated_at = ? WHERE id = 1", time.Now()).Error
+	if err != nil {
+		return fmt.Errorf("could not bump dataset version: %v", err)
+	}
+	return nil
+}
+
+// DeleteSource deletes the taxis and passengers loaded from a trip file into a
+// scenario, batchSize rows per statement so that large counties do not hold
+// long locks.
+func DeleteSource(db *gorm.DB, scenario string, source string, batchSize int) (int64, error) {
+	query := "DELETE FROM %[1]s WHERE scenario = ? AND source = ? LIMIT %[2]d"
+	if db.Dialect().GetName() == "postgres" {
+		// PostgreSQL has no DELETE ... LIMIT.
+		query = "DELETE FROM %[1]s WHERE ctid IN (SELECT ctid FROM %[1]s WHERE scenario = ? AND source = ? LIMIT %[2]d)"
+	}
+	var deleted int64
+	for _, table := range []string{"passengers", "taxis"} {
+		for {
+			result := db.Exec(fmt.Sprintf(query, table, batchSize), scenario, source)
+			if result.Error != nil {
+				return deleted, fmt.Errorf("could not delete %s of %s in scenario %s: %v", table, source, scenario, result.Error)
+			}
+			if table == "taxis" {
+				deleted += result.RowsAffected
+			}
+			if result.RowsAffected < int64(batchSize) {
+				break
+			}
+		}
+	}
+	return deleted, nil
+}
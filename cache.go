@@ -0,0 +1,298 @@
+package ataxi
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// maxCacheEntries bounds the number of memoized results. The cache is
+// emptied when it is full.
+const maxCacheEntries = 1024
+
+// cachedDB memoizes the aggregate queries of the wrapped database. Cached
+// results are dropped whenever the dataset version changes, i.e. after the
+// tables are reloaded. Callers must not modify the slices it returns.
+type cachedDB struct {
+	db            RideSharingDatabase
+	checkInterval time.Duration
+
+	mu      sync.Mutex
+	version string
+	checked time.Time
+	entries map[string]interface{}
+}
+
+var _ RideSharingDatabase = &cachedDB{}
+
+// NewCachedDB wraps db so that aggregate queries are only run once per
+// dataset version. The dataset version is checked at most once every
+// checkInterval.
+func NewCachedDB(db RideSharingDatabase, checkInterval time.Duration) RideSharingDatabase {
+	return &cachedDB{
+		db:            db,
+		checkInterval: checkInterval,
+		entries:       make(map[string]interface{}),
+	}
+}
+
+// DatasetVersion returns the dataset version, reading it from the database if
+// it has not been checked within the check interval. The cached results are
+// dropped when it has changed.
+func (c *cachedDB) DatasetVersion(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	if time.Since(c.checked) < c.checkInterval {
+		defer c.mu.Unlock()
+		return c.version, nil
+	}
+	c.mu.Unlock()
+
+	version, err := c.db.DatasetVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if version != c.version {
+		c.version = version
+		c.entries = make(map[string]interface{})
+	}
+	c.checked = time.Now()
+	return version, nil
+}
+
+// cacheKey identifies a query by its method and arguments. The arguments are
+// json encoded so that the values of pointer fields are compared rather than
+// the pointers.
+func cacheKey(method string, args ...interface{}) string {
+	key, _ := json.Marshal(args)
+	return method + string(key)
+}
+
+// get returns the cached result for key in the current dataset version, along
+// with that version.
+func (c *cachedDB) get(ctx context.Context, key string) (interface{}, string, bool, error) {
+	version, err := c.DatasetVersion(ctx)
+	if err != nil {
+		return nil, "", false, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result, ok := c.entries[key]
+	ok = ok && version == c.version
+	CacheRequests.WithLabelValues(cacheResult(ok)).Inc()
+	return result, version, ok, nil
+}
+
+// put caches the result for key, unless the dataset version changed while it
+// was computed.
+func (c *cachedDB) put(key string, version string, result interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if version != c.version {
+		return
+	}
+	if len(c.entries) >= maxCacheEntries {
+		c.entries = make(map[string]interface{})
+	}
+	c.entries[key] = result
+}
+
+// memoize returns the cached result for key, or computes and caches it with
+// query.
+func (c *cachedDB) memoize(ctx context.Context, key string, query func() (interface{}, error)) (interface{}, error) {
+	result, version, ok, err := c.get(ctx, key)
+	if err != nil || ok {
+		return result, err
+	}
+	result, err = query()
+	if err != nil {
+		return nil, err
+	}
+	c.put(key, version, result)
+	return result, nil
+}
+
+func cacheResult(hit bool) string {
+	if hit {
+		return "hit"
+	}
+	return "miss"
+}
+
+func (c *cachedDB) ListTaxis(ctx context.Context, orderBy string, limit int, withPassengers bool) ([]Taxi, error) {
+	return c.db.ListTaxis(ctx, orderBy, limit, withPassengers)
+}
+
+func (c *cachedDB) ListTaxisByDepartureTime(ctx context.Context, limit int, withPassengers bool) ([]Taxi, error) {
+	return c.db.ListTaxisByDepartureTime(ctx, limit, withPassengers)
+}
+
+func (c *cachedDB) ListTaxisByNumPassengers(ctx context.Context, limit int, withPassengers bool) ([]Taxi, error) {
+	return c.db.ListTaxisByNumPassengers(ctx, limit, withPassengers)
+}
+
+func (c *cachedDB) QueryTaxis(ctx context.Context, filter TaxiFilter, orderBy string, offset int, limit int, withPassengers bool) ([]Taxi, error) {
+	return c.db.QueryTaxis(ctx, filter, orderBy, offset, limit, withPassengers)
+}
+
+func (c *cachedDB) StreamTaxis(ctx context.Context, filter TaxiFilter, orderBy string, offset int, limit int, withPassengers bool, fn func(*Taxi) error) error {
+	return c.db.StreamTaxis(ctx, filter, orderBy, offset, limit, withPassengers, fn)
+}
+
+func (c *cachedDB) CountTaxis(ctx context.Context, filter TaxiFilter) (int, error) {
+	result, err := c.memoize(ctx, cacheKey("CountTaxis", filter), func() (interface{}, error) {
+		return c.db.CountTaxis(ctx, filter)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result.(int), nil
+}
+
+func (c *cachedDB) GetTaxi(ctx context.Context, id uint) (*Taxi, error) {
+	return c.db.GetTaxi(ctx, id)
+}
+
+func (c *cachedDB) ListPassengers(ctx context.Context, limit int) ([]Passenger, error) {
+	return c.db.ListPassengers(ctx, limit)
+}
+
+func (c *cachedDB) StreamPassengers(ctx context.Context, limit int, fn func(*Passenger) error) error {
+	return c.db.StreamPassengers(ctx, limit, fn)
+}
+
+func (c *cachedDB) ListPassengersForTaxi(ctx context.Context, taxiID uint) ([]Passenger, error) {
+	return c.db.ListPassengersForTaxi(ctx, taxiID)
+}
+
+func (c *cachedDB) GetPassenger(ctx context.Context, id uint) (*Passenger, error) {
+	return c.db.GetPassenger(ctx, id)
+}
+
+func (c *cachedDB) GetDemandForPixels(ctx context.Context, query SupplyDemandQuery) ([]SuperPixelDemand, error) {
+	result, err := c.memoize(ctx, cacheKey("GetDemandForPixels", query), func() (interface{}, error) {
+		return c.db.GetDemandForPixels(ctx, query)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]SuperPixelDemand), nil
+}
+
+func (c *cachedDB) GetSupplyForPixels(ctx context.Context, query SupplyDemandQuery) ([]SuperPixelSupply, error) {
+	result, err := c.memoize(ctx, cacheKey("GetSupplyForPixels", query), func() (interface{}, error) {
+		return c.db.GetSupplyForPixels(ctx, query)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]SuperPixelSupply), nil
+}
+
+func (c *cachedDB) GetNumTripsForCategory(ctx context.Context, category int) (int, error) {
+	result, err := c.memoize(ctx, cacheKey("GetNumTripsForCategory", category), func() (interface{}, error) {
+		return c.db.GetNumTripsForCategory(ctx, category)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result.(int), nil
+}
+
+func (c *cachedDB) GetCumulativeNumTripsForCategory(ctx context.Context, category int) (int, error) {
+	result, err := c.memoize(ctx, cacheKey("GetCumulativeNumTripsForCategory", category), func() (interface{}, error) {
+		return c.db.GetCumulativeNumTripsForCategory(ctx, category)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result.(int), nil
+}
+
+func (c *cachedDB) GetAVOStats(ctx context.Context, groupBy string, filter TaxiFilter) ([]AVOStats, error) {
+	result, err := c.memoize(ctx, cacheKey("GetAVOStats", groupBy, filter), func() (interface{}, error) {
+		return c.db.GetAVOStats(ctx, groupBy, filter)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]AVOStats), nil
+}
+
+func (c *cachedDB) GetSupplyDemandByGroup(ctx context.Context, groupBy string, filter TaxiFilter) ([]GroupSupplyDemand, error) {
+	result, err := c.memoize(ctx, cacheKey("GetSupplyDemandByGroup", groupBy, filter), func() (interface{}, error) {
+		return c.db.GetSupplyDemandByGroup(ctx, groupBy, filter)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]GroupSupplyDemand), nil
+}
+
+func (c *cachedDB) ListScenarios(ctx context.Context) ([]ScenarioSummary, error) {
+	result, err := c.memoize(ctx, cacheKey("ListScenarios"), func() (interface{}, error) {
+		return c.db.ListScenarios(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]ScenarioSummary), nil
+}
+
+func (c *cachedDB) ListTaxisWithinRadius(ctx context.Context, lat float64, lon float64, radius float64, filter TaxiFilter, limit int) ([]Taxi, error) {
+	return c.db.ListTaxisWithinRadius(ctx, lat, lon, radius, filter, limit)
+}
+
+func (c *cachedDB) ListTaxisWithinPolygon(ctx context.Context, polygon string, filter TaxiFilter, limit int) ([]Taxi, error) {
+	return c.db.ListTaxisWithinPolygon(ctx, polygon, filter, limit)
+}
+
+// GetDemandForPolygons is not memoized, as the polygons would make the cache
+// keys far larger than the results.
+func (c *cachedDB) GetDemandForPolygons(ctx context.Context, polygons []Polygon, filter TaxiFilter) ([]PolygonDemand, error) {
+	return c.db.GetDemandForPolygons(ctx, polygons, filter)
+}
+
+func (c *cachedDB) ListRuns(ctx context.Context) ([]Run, error) {
+	result, err := c.memoize(ctx, cacheKey("ListRuns"), func() (interface{}, error) {
+		return c.db.ListRuns(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]Run), nil
+}
+
+func (c *cachedDB) GetCountySummaries(ctx context.Context, runID uint) ([]CountySummary, error) {
+	result, err := c.memoize(ctx, cacheKey("GetCountySummaries", runID), func() (interface{}, error) {
+		return c.db.GetCountySummaries(ctx, runID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]CountySummary), nil
+}
+
+func (c *cachedDB) GetStateSummaries(ctx context.Context, runID uint) ([]StateSummary, error) {
+	result, err := c.memoize(ctx, cacheKey("GetStateSummaries", runID), func() (interface{}, error) {
+		return c.db.GetStateSummaries(ctx, runID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]StateSummary), nil
+}
+
+func (c *cachedDB) SchemaVersion(ctx context.Context) (uint, error) {
+	return c.db.SchemaVersion(ctx)
+}
+
+func (c *cachedDB) Ping(ctx context.Context) error {
+	return c.db.Ping(ctx)
+}
+
+func (c *cachedDB) Close() {
+	c.db.Close()
+}
@@ -0,0 +1,65 @@
+package ataxi
+
+import (
+	"fmt"
+	"math"
+)
+
+// SecondsPerDay is the length of one simulated day.
+const SecondsPerDay = 86400
+
+// TaxiSpeed is the average speed in mph used to estimate how long a taxi is
+// occupied by a trip.
+const TaxiSpeed = 30
+
+// SimTime is a point on the simulation clock, in seconds since midnight of the
+// first simulated day. Unlike a time of day it keeps counting past midnight, so
+// trips that end on a later day keep their ordering and duration.
+type SimTime uint32
+
+// Day returns the zero-based simulated day t falls on.
+func (t SimTime) Day() int {
+	return int(t) / SecondsPerDay
+}
+
+// TimeOfDay returns the number of seconds since midnight of t's day.
+func (t SimTime) TimeOfDay() int {
+	return int(t) % SecondsPerDay
+}
+
+// Hour returns the hour of the day (0-23) t falls in.
+func (t SimTime) Hour() int {
+	return t.TimeOfDay() / 3600
+}
+
+// Minute returns the number of whole minutes since the start of the simulation.
+func (t SimTime) Minute() int {
+	return int(t) / 60
+}
+
+// MinuteOfDay returns the minute of the day (0-1439) t falls in.
+func (t SimTime) MinuteOfDay() int {
+	return t.TimeOfDay() / 60
+}
+
+// Add returns t advanced by the given number of seconds.
+func (t SimTime) Add(seconds uint32) SimTime {
+	return t + SimTime(seconds)
+}
+
+// Before reports whether t is strictly earlier than u.
+func (t SimTime) Before(u SimTime) bool {
+	return t < u
+}
+
+// String formats t as "day D HH:MM:SS".
+func (t SimTime) String() string {
+	s := t.TimeOfDay()
+	return fmt.Sprintf("day %d %02d:%02d:%02d", t.Day(), s/3600, (s%3600)/60, s%60)
+}
+
+// TravelTime returns the number of seconds needed to drive the given number of
+// miles at TaxiSpeed, rounded up.
+func TravelTime(miles float64) uint32 {
+	return uint32(math.Ceil(miles / TaxiSpeed * 3600))
+}
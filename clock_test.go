@@ -0,0 +1,43 @@
+package ataxi
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSimTime(t *testing.T) {
+	tests := []struct {
+		t    SimTime
+		want string
+	}{
+		{0, "day 0 00:00:00 0 0 0 0"},
+		{7*3600 + 5, "day 0 07:00:05 0 7 420 420"},
+		{SecondsPerDay - 1, "day 0 23:59:59 0 23 1439 1439"},
+		{SecondsPerDay + 30*60, "day 1 00:30:00 1 0 30 1470"},
+		{3*SecondsPerDay + 19*3600, "day 3 19:00:00 3 19 1140 5460"},
+	}
+	for _, test := range tests {
+		got := fmt.Sprint(test.t, " ", test.t.Day(), " ", test.t.Hour(), " ", test.t.MinuteOfDay(), " ", test.t.Minute())
+		if got != test.want {
+			t.Errorf("SimTime(%d): got %s, want %s", uint32(test.t), got, test.want)
+		}
+	}
+}
+
+func TestGetTimeCategoryPastMidnight(t *testing.T) {
+	tests := []struct {
+		seconds int
+		want    int
+	}{
+		{5 * 3600, 0},
+		{8 * 3600, 1},
+		{20 * 3600, 5},
+		{SecondsPerDay + 8*3600, 1},
+		{2*SecondsPerDay + 17*3600, 4},
+	}
+	for _, test := range tests {
+		if got := GetTimeCategory(test.seconds); got != test.want {
+			t.Errorf("GetTimeCategory(%d) = %d, want %d", test.seconds, got, test.want)
+		}
+	}
+}
@@ -2,25 +2,165 @@ package ataxi
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"log"
+	"net/url"
 	"os"
+	"time"
 )
 
+// ConfigPathEnv is the environment variable holding the path of the config
+// file, used when no path is given explicitly.
+const ConfigPathEnv = "ATAXI_CONFIG"
+
+// DefaultConfigPath is the config file used when neither a path nor
+// ConfigPathEnv is given, relative to the command directories.
+const DefaultConfigPath = "../config.json"
+
 type AppConfig struct {
 	Username         string
 	Password         string
 	Database         string
+	Host             string `json:"host"`
+	DBDriver         string `json:"db_driver"`
+	DSN              string `json:"dsn"`
+	ListenAddr       string `json:"listen_addr"`
+	StaticDir        string `json:"static_dir"`
+	TemplateDir      string `json:"template_dir"`
+	CacheInterval    string `json:"cache_interval"`
+	QueryTimeout     string `json:"query_timeout"`
+	StreamTimeout    string `json:"stream_timeout"`
 	GoogleMapsAPIKey string `json:"google_maps_api_key"`
+	MapTiles         string `json:"map_tiles"`
 }
 
 var Config AppConfig
 
-func init() {
-	raw, err := ioutil.ReadFile("../config.json")
+// DefaultConfig returns the configuration used for settings missing from the
+// config file.
+func DefaultConfig() AppConfig {
+	return AppConfig{
+		Host:          "127.0.0.1:3306",
+		DBDriver:      "mysql",
+		ListenAddr:    ":8080",
+		StaticDir:     "./static/",
+		TemplateDir:   "templates",
+		CacheInterval: "30s",
+		QueryTimeout:  "1m",
+		StreamTimeout: "1h",
+	}
+}
+
+// configEnv maps environment variables to the settings they override.
+func configEnv(config *AppConfig) map[string]*string {
+	return map[string]*string{
+		"ATAXI_DB_DRIVER":      &config.DBDriver,
+		"ATAXI_DB_DSN":         &config.DSN,
+		"ATAXI_DB_HOST":        &config.Host,
+		"ATAXI_LISTEN_ADDR":    &config.ListenAddr,
+		"ATAXI_STATIC_DIR":     &config.StaticDir,
+		"ATAXI_TEMPLATE_DIR":   &config.TemplateDir,
+		"ATAXI_CACHE_INTERVAL": &config.CacheInterval,
+		"ATAXI_QUERY_TIMEOUT":  &config.QueryTimeout,
+		"ATAXI_STREAM_TIMEOUT": &config.StreamTimeout,
+	}
+}
+
+// LoadConfig reads the config file at path, falling back to ConfigPathEnv and
+// then DefaultConfigPath when path is empty. Settings missing from the file
+// take their DefaultConfig values, and ATAXI_* environment variables override
+// the file. A missing file is only an error when its path was given
+// explicitly.
+func LoadConfig(path string) (AppConfig, error) {
+	config := DefaultConfig()
+	explicit := true
+	if path == "" {
+		path = os.Getenv(ConfigPathEnv)
+	}
+	if path == "" {
+		path = DefaultConfigPath
+		explicit = false
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(raw, &config); err != nil {
+			return config, fmt.Errorf("config: could not parse %s: %v", path, err)
+		}
+	} else if explicit || !os.IsNotExist(err) {
+		return config, fmt.Errorf("config: could not read %s: %v", path, err)
+	}
+	for env, setting := range configEnv(&config) {
+		if value, ok := os.LookupEnv(env); ok {
+			*setting = value
+		}
+	}
+	return config, nil
+}
+
+// DataSourceName returns the DSN used to connect to the configured database.
+// An explicit DSN takes precedence over the username, password, host and
+// database settings, which are formatted for the DB driver.
+func (config AppConfig) DataSourceName() string {
+	if config.DSN != "" {
+		return config.DSN
+	}
+	if config.DBDriver == "postgres" {
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(config.Username, config.Password),
+			Host:     config.Host,
+			Path:     "/" + config.Database,
+			RawQuery: "sslmode=disable",
+		}
+		return dsn.String()
+	}
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8&parseTime=True&loc=Local",
+		config.Username, config.Password, config.Host, config.Database)
+}
+
+// CacheCheckInterval returns how often the query cache checks the dataset
+// version, or 0 if aggregate queries should not be cached.
+func (config AppConfig) CacheCheckInterval() (time.Duration, error) {
+	if config.CacheInterval == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(config.CacheInterval)
 	if err != nil {
-		log.Fatal(err)
-		os.Exit(1)
+		return 0, fmt.Errorf("config: invalid cache_interval %q: %v", config.CacheInterval, err)
+	}
+	return interval, nil
+}
+
+// RequestTimeout returns how long the database queries of a request may
+// take, or 0 if they are only canceled when the client goes away.
+func (config AppConfig) RequestTimeout() (time.Duration, error) {
+	return parseTimeout("query_timeout", config.QueryTimeout)
+}
+
+// StreamRequestTimeout is RequestTimeout for the requests whose responses are
+// streamed from the database, such as csv exports, which may take longer.
+func (config AppConfig) StreamRequestTimeout() (time.Duration, error) {
+	return parseTimeout("stream_timeout", config.StreamTimeout)
+}
+
+func parseTimeout(name string, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("config: invalid %s %q: %v", name, value, err)
+	}
+	return timeout, nil
+}
+
+// OpenDB opens the database selected by the config's DB driver.
+func OpenDB(config AppConfig) (RideSharingDatabase, error) {
+	switch config.DBDriver {
+	case "mysql":
+		return newMySQLDB(config)
+	case "postgres":
+		return newPostgresDB(config)
 	}
-	json.Unmarshal(raw, &Config)
+	return nil, fmt.Errorf("config: unsupported db driver %q", config.DBDriver)
 }
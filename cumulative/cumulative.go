@@ -5,33 +5,45 @@ import (
 	"encoding/csv"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 )
 
+var categoryColumns = map[string]bool{
+	"WalkTrips":       true,
+	"ShortTrips":      true,
+	"NormalTrips":     true,
+	"LongTrips":       true,
+	"ReallyLongTrips": true,
+}
+
 func main() {
 	if len(os.Args) != 2 {
 		log.Fatal(errors.New("You must provide a region totals csv."))
 		os.Exit(1)
 	}
-	csvFile, _ := os.Open(os.Args[1])
+	csvFile, err := os.Open(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer csvFile.Close()
 	reader := csv.NewReader(bufio.NewReader(csvFile))
-	if _, err := reader.Read(); err != nil {
+	columns, err := reader.Read()
+	if err != nil {
 		log.Fatal(err)
 	}
-	var totals [5]int
-	line, _ := reader.Read()
-	for i, field := range line {
-		value, _ := strconv.ParseInt(field, 10, 64)
-		if i != 0 {
-			totals[i] = int(value) + totals[i-1]
-		} else {
-			totals[i] = int(value)
-		}
+
+	// The OD flows of region_totals are accumulated into their own file, so
+	// that they do not overwrite the cumulative region totals.
+	output := "cumulative.csv"
+	if len(columns) > 0 && columns[0] == "OFIPS" {
+		output = "od_flows_cumulative.csv"
 	}
-	fmt.Println("Creating cumulative.csv")
-	file, err := os.Create("../data/cumulative.csv")
+	fmt.Printf("Creating %s\n", output)
+	file, err := os.Create(filepath.Join("../data", output))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -40,13 +52,34 @@ func main() {
 	writer := csv.NewWriter(file)
 	defer writer.Flush()
 
-	columns := []string{"WalkTrips", "ShortTrips", "NormalTrips", "LongTrips", "ReallyLongTrips"}
 	writer.Write(columns)
 
-	var row []string
-	for c := 0; c < 5; c++ {
-		row = append(row, strconv.Itoa(totals[c]))
+	// Key columns such as Level and FIPS are copied as is; the trip category
+	// columns are summed up to and including each category.
+	var counter int
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		var total int
+		row := make([]string, len(line))
+		for i, field := range line {
+			if !categoryColumns[columns[i]] {
+				row[i] = field
+				continue
+			}
+			value, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				log.Fatalf("invalid %s count %q: %v", columns[i], field, err)
+			}
+			total += int(value)
+			row[i] = strconv.Itoa(total)
+		}
+		writer.Write(row)
+		counter++
 	}
-	writer.Write(row)
-	fmt.Println("Finished")
+	fmt.Printf("Finished %d row(s)\n", counter)
 }
@@ -0,0 +1,108 @@
+package ataxi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/lib/pq"
+)
+
+// postgresDialect is the SQL specific to PostgreSQL.
+var postgresDialect = sqlDialect{
+	name: "postgres",
+	div: func(expr string, n int) string {
+		return fmt.Sprintf("DIV(CAST(%s AS NUMERIC), %d)", expr, n)
+	},
+}
+
+// postgresDB is a PostgreSQL database with the PostGIS extension. The origins
+// and destinations of taxis and passengers are also stored as PostGIS points,
+// in the o_geom and d_geom columns, which the spatial queries use.
+type postgresDB struct {
+	*sqlDB
+}
+
+var _ RideSharingDatabase = &postgresDB{}
+
+func newPostgresDB(config AppConfig) (RideSharingDatabase, error) {
+	conn, err := gorm.Open("postgres", config.DataSourceName())
+	if err != nil {
+		return nil, fmt.Errorf("postgres: could not get a connection: %v", err)
+	}
+	db := &postgresDB{
+		sqlDB: &sqlDB{
+			conn:    conn,
+			dialect: postgresDialect,
+		},
+	}
+	return db, nil
+}
+
+// ListTaxisWithinRadius returns the taxis matching filter departing within
+// radius meters of a point, ordered by departure time.
+func (db *postgresDB) ListTaxisWithinRadius(ctx context.Context, lat float64, lon float64, radius float64, filter TaxiFilter, limit int) ([]Taxi, error) {
+	var taxis []Taxi
+	conn := whereTaxis(db.conn.Model(&Taxi{}), filter).
+		Where("ST_DWithin(CAST(o_geom AS geography), CAST(ST_SetSRID(ST_MakePoint(?, ?), 4326) AS geography), ?)",
+			lon, lat, radius).
+		Order("departure_time asc, id asc").Limit(limit)
+	err := db.find(ctx, conn, &taxis)
+	if err != nil {
+		return nil, db.errorf("could not retrieve taxis within %g m of %g, %g: %w", radius, lat, lon, err)
+	}
+	return taxis, nil
+}
+
+// ListTaxisWithinPolygon returns the taxis matching filter departing within a
+// GeoJSON polygon, ordered by departure time.
+func (db *postgresDB) ListTaxisWithinPolygon(ctx context.Context, polygon string, filter TaxiFilter, limit int) ([]Taxi, error) {
+	var taxis []Taxi
+	conn := whereTaxis(db.conn.Model(&Taxi{}), filter).
+		Where("ST_Intersects(o_geom, ST_SetSRID(ST_GeomFromGeoJSON(?), 4326))", polygon).
+		Order("departure_time asc, id asc").Limit(limit)
+	err := db.find(ctx, conn, &taxis)
+	if err != nil {
+		return nil, db.errorf("could not retrieve taxis within polygon: %w", err)
+	}
+	return taxis, nil
+}
+
+// GetDemandForPolygons returns the number of taxis matching filter departing
+// from each polygon, in the order of polygons. A taxi departing from where
+// polygons overlap counts in each of them.
+func (db *postgresDB) GetDemandForPolygons(ctx context.Context, polygons []Polygon, filter TaxiFilter) ([]PolygonDemand, error) {
+	if 2*len(polygons) > maxPlaceholders {
+		return nil, db.errorf("cannot aggregate demand to more than %d polygons: %w", maxPlaceholders/2, ErrInvalidArgument)
+	}
+	values := make([]string, len(polygons))
+	args := make([]interface{}, 0, 2*len(polygons))
+	for i, polygon := range polygons {
+		values[i] = "(?, ST_SetSRID(ST_GeomFromGeoJSON(?), 4326))"
+		args = append(args, polygon.Key, polygon.Geometry)
+	}
+	var counts []PolygonDemand
+	if len(polygons) > 0 {
+		conn := whereTaxis(db.conn.Model(&Taxi{}), filter).
+			Joins("JOIN (VALUES "+strings.Join(values, ", ")+") AS polygons (group_key, geom) "+
+				"ON ST_Intersects(taxis.o_geom, polygons.geom)", args...).
+			Select("polygons.group_key AS group_key, COUNT(*) AS demand, SUM(taxis.num_passengers) AS num_passengers").
+			Group("polygons.group_key")
+		err := db.find(ctx, conn, &counts)
+		if err != nil {
+			return nil, db.errorf("could not compute demand for polygons: %w", err)
+		}
+	}
+	byKey := make(map[string]PolygonDemand, len(counts))
+	for _, count := range counts {
+		byKey[count.Key] = count
+	}
+	results := make([]PolygonDemand, len(polygons))
+	for i, polygon := range polygons {
+		results[i] = byKey[polygon.Key]
+		results[i].Key = polygon.Key
+		results[i].Name = polygon.Name
+	}
+	return results, nil
+}
@@ -0,0 +1,661 @@
+package ataxi
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+
+	"github.com/jinzhu/gorm"
+)
+
+// sqlDB implements RideSharingDatabase with gorm, for the SQL databases whose
+// differences are captured by its dialect.
+type sqlDB struct {
+	conn    *gorm.DB
+	dialect sqlDialect
+}
+
+var _ RideSharingDatabase = &sqlDB{}
+
+// sqlDialect is the SQL that differs between the supported databases.
+type sqlDialect struct {
+	// name prefixes the errors of the database.
+	name string
+	// div returns the sql expression dividing expr by n, truncating toward
+	// zero.
+	div func(expr string, n int) string
+}
+
+// errorf returns an error prefixed with the database name.
+func (db *sqlDB) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf(db.dialect.name+": "+format, args...)
+}
+
+// querySQL returns the SELECT statement of conn and its arguments. gorm v1
+// does not pass contexts down, so the queries are built with gorm on db.conn,
+// keeping its settings, and run with QueryContext by query, scanRow, find and
+// first, so that they are canceled when ctx is done.
+func querySQL(conn *gorm.DB) (string, []interface{}, error) {
+	if conn.Error != nil {
+		return "", nil, conn.Error
+	}
+	scope := conn.NewScope(conn.Value)
+	scope.Raw(scope.AddToVars(conn.QueryExpr()))
+	return scope.SQL, scope.SQLVars, nil
+}
+
+// query runs the query of conn with ctx.
+func (db *sqlDB) query(ctx context.Context, conn *gorm.DB) (*sql.Rows, error) {
+	query, args, err := querySQL(conn)
+	if err != nil {
+		return nil, err
+	}
+	return db.conn.DB().QueryContext(ctx, query, args...)
+}
+
+// scanRow runs the query of conn with ctx and scans the columns of its first
+// row into dest.
+func (db *sqlDB) scanRow(ctx context.Context, conn *gorm.DB, dest ...interface{}) error {
+	query, args, err := querySQL(conn)
+	if err != nil {
+		return err
+	}
+	return db.conn.DB().QueryRowContext(ctx, query, args...).Scan(dest...)
+}
+
+// find runs the query of conn with ctx and scans its rows into dest, a
+// pointer to a slice of structs, as conn.Find(dest) does.
+func (db *sqlDB) find(ctx context.Context, conn *gorm.DB, dest interface{}) error {
+	rows, err := db.query(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	results := reflect.ValueOf(dest).Elem()
+	results.Set(reflect.MakeSlice(results.Type(), 0, 0))
+	for rows.Next() {
+		result := reflect.New(results.Type().Elem())
+		if err := db.conn.ScanRows(rows, result.Interface()); err != nil {
+			return err
+		}
+		results.Set(reflect.Append(results, result.Elem()))
+	}
+	return rows.Err()
+}
+
+// first runs the query of conn with ctx and scans its first row into dest,
+// returning gorm.ErrRecordNotFound if there is none.
+func (db *sqlDB) first(ctx context.Context, conn *gorm.DB, dest interface{}) error {
+	rows, err := db.query(ctx, conn.Limit(1))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return gorm.ErrRecordNotFound
+	}
+	return db.conn.ScanRows(rows, dest)
+}
+
+// ListTaxis returns a list of taxis, ordered by field.
+func (db *sqlDB) ListTaxis(ctx context.Context, orderBy string, limit int, withPassengers bool) ([]Taxi, error) {
+	var taxis []Taxi
+	var err error
+	if orderBy == "departure_time" {
+		taxis, err = db.ListTaxisByDepartureTime(ctx, limit, withPassengers)
+	} else {
+		taxis, err = db.ListTaxisByNumPassengers(ctx, limit, withPassengers)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return taxis, nil
+}
+
+// ListTaxisByDepartureTime returns the first limit taxis, earliest departure
+// first.
+func (db *sqlDB) ListTaxisByDepartureTime(ctx context.Context, limit int, withPassengers bool) ([]Taxi, error) {
+	return db.QueryTaxis(ctx, TaxiFilter{}, "departure_time", 0, limit, withPassengers)
+}
+
+// ListTaxisByNumPassengers returns the first limit taxis, fullest first.
+func (db *sqlDB) ListTaxisByNumPassengers(ctx context.Context, limit int, withPassengers bool) ([]Taxi, error) {
+	return db.QueryTaxis(ctx, TaxiFilter{}, "num_passengers", 0, limit, withPassengers)
+}
+
+// whereTaxis restricts a query on the taxis table to the taxis matching filter.
+func whereTaxis(conn *gorm.DB, filter TaxiFilter) *gorm.DB {
+	if filter.ID != nil {
+		conn = conn.Where("id = ?", *filter.ID)
+	}
+	if filter.OX != nil {
+		conn = conn.Where("ox = ?", *filter.OX)
+	}
+	if filter.OY != nil {
+		conn = conn.Where("oy = ?", *filter.OY)
+	}
+	if filter.DXSuper != nil {
+		conn = conn.Where("dx_super = ?", *filter.DXSuper)
+	}
+	if filter.DYSuper != nil {
+		conn = conn.Where("dy_super = ?", *filter.DYSuper)
+	}
+	if filter.OFIPS != nil {
+		conn = conn.Where("o_fips = ?", *filter.OFIPS)
+	}
+	if filter.DFIPS != nil {
+		conn = conn.Where("d_fips = ?", *filter.DFIPS)
+	}
+	if filter.DepartureStart != nil {
+		conn = conn.Where("departure_time >= ?", *filter.DepartureStart)
+	}
+	if filter.DepartureEnd != nil {
+		conn = conn.Where("departure_time < ?", *filter.DepartureEnd)
+	}
+	if filter.NumPassengers != nil {
+		conn = conn.Where("num_passengers = ?", *filter.NumPassengers)
+	}
+	if filter.TripCategory != nil {
+		conn = conn.Where("EXISTS (SELECT 1 FROM passengers WHERE passengers.taxi_id = taxis.id AND passengers.trip_category = ?)",
+			*filter.TripCategory)
+	}
+	if filter.Scenario != nil {
+		conn = conn.Where("taxis.scenario = ?", *filter.Scenario)
+	}
+	return conn
+}
+
+// taxiOrder returns the ORDER BY clause of a taxi ordering.
+func taxiOrder(orderBy string) (string, error) {
+	switch orderBy {
+	case "departure_time":
+		return "departure_time asc, id asc", nil
+	case "num_passengers":
+		return "num_passengers desc, id asc", nil
+	}
+	return "", fmt.Errorf("cannot order taxis by %q: %w", orderBy, ErrInvalidArgument)
+}
+
+// QueryTaxis returns the taxis matching filter, ordered by field and skipping
+// the first offset taxis.
+func (db *sqlDB) QueryTaxis(ctx context.Context, filter TaxiFilter, orderBy string, offset int, limit int, withPassengers bool) ([]Taxi, error) {
+	order, err := taxiOrder(orderBy)
+	if err != nil {
+		return nil, err
+	}
+	conn := whereTaxis(db.conn.Model(&Taxi{}), filter).Order(order).Offset(offset).Limit(limit)
+	var taxis []Taxi
+	if err := db.find(ctx, conn, &taxis); err != nil {
+		return nil, db.errorf("could not retrieve taxis: %w", err)
+	}
+	if withPassengers {
+		if err := db.loadPassengers(ctx, taxis); err != nil {
+			return nil, err
+		}
+	}
+	return taxis, nil
+}
+
+// streamBatchSize is the number of streamed taxis whose passengers are loaded
+// with a single query.
+const streamBatchSize = 500
+
+// StreamTaxis calls fn with each taxi matching filter, ordered by field and
+// skipping the first offset taxis, reading the rows as fn consumes them. A
+// limit of 0 streams every matching taxi.
+func (db *sqlDB) StreamTaxis(ctx context.Context, filter TaxiFilter, orderBy string, offset int, limit int, withPassengers bool, fn func(*Taxi) error) error {
+	order, err := taxiOrder(orderBy)
+	if err != nil {
+		return err
+	}
+	if limit <= 0 {
+		// MySQL ignores an offset without a limit.
+		limit = math.MaxInt64
+	}
+	rows, err := db.query(ctx, whereTaxis(db.conn.Model(&Taxi{}), filter).Order(order).Offset(offset).Limit(limit))
+	if err != nil {
+		return db.errorf("could not retrieve taxis: %w", err)
+	}
+	defer rows.Close()
+
+	batch := make([]Taxi, 0, streamBatchSize)
+	flush := func() error {
+		if withPassengers && len(batch) > 0 {
+			if err := db.loadPassengers(ctx, batch); err != nil {
+				return err
+			}
+		}
+		for i := range batch {
+			if err := fn(&batch[i]); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+	for rows.Next() {
+		var taxi Taxi
+		if err := db.conn.ScanRows(rows, &taxi); err != nil {
+			return db.errorf("could not read taxi: %w", err)
+		}
+		batch = append(batch, taxi)
+		if len(batch) == streamBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return db.errorf("could not retrieve taxis: %w", err)
+	}
+	return flush()
+}
+
+// loadPassengers fills in the passengers of taxis.
+func (db *sqlDB) loadPassengers(ctx context.Context, taxis []Taxi) error {
+	if len(taxis) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(taxis))
+	byID := make(map[uint]*Taxi, len(taxis))
+	for i := range taxis {
+		ids[i] = taxis[i].ID
+		byID[taxis[i].ID] = &taxis[i]
+		taxis[i].Passengers = []Passenger{}
+	}
+	var passengers []Passenger
+	conn := db.conn.Model(&Passenger{}).Where("taxi_id IN (?)", ids).Order("departure_time asc, id asc")
+	err := db.find(ctx, conn, &passengers)
+	if err != nil {
+		return db.errorf("could not retrieve passengers: %w", err)
+	}
+	for _, passenger := range passengers {
+		taxi := byID[passenger.TaxiID]
+		taxi.Passengers = append(taxi.Passengers, passenger)
+	}
+	return nil
+}
+
+// CountTaxis returns the number of taxis matching filter.
+func (db *sqlDB) CountTaxis(ctx context.Context, filter TaxiFilter) (int, error) {
+	var count int
+	if err := db.scanRow(ctx, whereTaxis(db.conn.Model(&Taxi{}), filter).Select("COUNT(*)"), &count); err != nil {
+		return 0, db.errorf("could not count taxis: %w", err)
+	}
+	return count, nil
+}
+
+// GetTaxi retrieves a taxi by its ID.
+func (db *sqlDB) GetTaxi(ctx context.Context, id uint) (*Taxi, error) {
+	var taxi Taxi
+	err := db.first(ctx, db.conn.Model(&Taxi{}).Where("id = ?", id), &taxi)
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, db.errorf("could not find taxi with id %d: %w", id, ErrNotFound)
+	} else if err != nil {
+		return nil, db.errorf("could not retrieve taxi with id %d: %w", id, err)
+	}
+	taxis := []Taxi{taxi}
+	if err := db.loadPassengers(ctx, taxis); err != nil {
+		return nil, err
+	}
+	return &taxis[0], nil
+}
+
+// ListPassengers returns a list of passengers, ordered by departure time.
+func (db *sqlDB) ListPassengers(ctx context.Context, limit int) ([]Passenger, error) {
+	var passengers []Passenger
+	if err := db.find(ctx, db.conn.Model(&Passenger{}).Limit(limit).Order("departure_time asc, id asc"), &passengers); err != nil {
+		return nil, db.errorf("could not retrieve passengers: %w", err)
+	}
+	return passengers, nil
+}
+
+// StreamPassengers calls fn with each passenger, ordered by departure time,
+// reading the rows as fn consumes them. A limit of 0 streams every passenger.
+func (db *sqlDB) StreamPassengers(ctx context.Context, limit int, fn func(*Passenger) error) error {
+	conn := db.conn.Model(&Passenger{}).Order("departure_time asc, id asc")
+	if limit > 0 {
+		conn = conn.Limit(limit)
+	}
+	rows, err := db.query(ctx, conn)
+	if err != nil {
+		return db.errorf("could not retrieve passengers: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var passenger Passenger
+		if err := db.conn.ScanRows(rows, &passenger); err != nil {
+			return db.errorf("could not read passenger: %w", err)
+		}
+		if err := fn(&passenger); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return db.errorf("could not retrieve passengers: %w", err)
+	}
+	return nil
+}
+
+// ListPassengersForTaxi returns the passengers of a taxi, ordered by departure time.
+func (db *sqlDB) ListPassengersForTaxi(ctx context.Context, taxiID uint) ([]Passenger, error) {
+	var passengers []Passenger
+	err := db.find(ctx, db.conn.Model(&Passenger{}).Where("taxi_id = ?", taxiID).Order("departure_time asc, id asc"), &passengers)
+	if err != nil {
+		return nil, db.errorf("could not retrieve passengers of taxi %d: %w", taxiID, err)
+	}
+	return passengers, nil
+}
+
+// GetPassenger retrieves a passenger by its ID.
+func (db *sqlDB) GetPassenger(ctx context.Context, id uint) (*Passenger, error) {
+	var passenger Passenger
+	err := db.first(ctx, db.conn.Model(&Passenger{}).Where("id = ?", id), &passenger)
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, db.errorf("could not find passenger with id %d: %w", id, ErrNotFound)
+	} else if err != nil {
+		return nil, db.errorf("could not retrieve passenger with id %d: %w", id, err)
+	}
+	return &passenger, nil
+}
+
+// superCoord returns the sql expression mapping a pixel coordinate column to
+// its superpixel coordinate, the same way GetSuperPixel does.
+func (d sqlDialect) superCoord(column string, size int32) string {
+	if size == 1 {
+		return column
+	}
+	return fmt.Sprintf("CASE WHEN %[1]s < 0 THEN -1 + %[2]s * %[4]d ELSE %[3]s * %[4]d END",
+		column, d.div(column+" + 1", int(size)), d.div(column, int(size)), size)
+}
+
+// madeEmptyTime is the sql expression of the time a taxi drops off its last
+// passenger, as computed by Taxi.MadeEmptyTime.
+var madeEmptyTime = fmt.Sprintf("departure_time + CEIL(vmt * 3600 / %d)", TaxiSpeed)
+
+// supplyDemandQuery groups the taxis in the query window by superpixel and
+// time bucket, using the given pixel and lat/lon coordinate column prefix ("o"
+// or "d") and time expression.
+func (db *sqlDB) supplyDemandQuery(ctx context.Context, query SupplyDemandQuery, end string, t string, results interface{}) error {
+	if query.Size < 1 || query.Size > MaxSuperPixelSize {
+		return fmt.Errorf("superpixel of dimension %[1]dx%[1]d is not supported: %[2]w", query.Size, ErrInvalidArgument)
+	}
+	bucket := "0"
+	if query.Bucket > 0 {
+		bucket = fmt.Sprintf("%s * %d", db.dialect.div(t, int(query.Bucket)), query.Bucket)
+	}
+	conn := db.conn.Model(&Taxi{})
+	if query.Start != nil {
+		conn = conn.Where(t+" >= ?", *query.Start)
+	}
+	if query.End != nil {
+		conn = conn.Where(t+" < ?", *query.End)
+	}
+	if query.Scenario != nil {
+		conn = conn.Where("scenario = ?", *query.Scenario)
+	}
+	return db.find(ctx, conn.Select(fmt.Sprintf("COUNT(*) AS c, %s AS x, %s AS y, %s AS bucket, "+
+		"MIN(%[4]s_lat) AS min_lat, MAX(%[4]s_lat) AS max_lat, MIN(%[4]s_lon) AS min_lon, MAX(%[4]s_lon) AS max_lon",
+		db.dialect.superCoord(end+"x", query.Size), db.dialect.superCoord(end+"y", query.Size), bucket, end)).
+		Group("x, y, bucket"), results)
+}
+
+// GetDemandForPixels returns the number of taxis departing from each
+// superpixel, by departure time.
+func (db *sqlDB) GetDemandForPixels(ctx context.Context, query SupplyDemandQuery) ([]SuperPixelDemand, error) {
+	var results []SuperPixelDemand
+	if err := db.supplyDemandQuery(ctx, query, "o", "departure_time", &results); err != nil {
+		return nil, db.errorf("could not retrieve demand for pixels: %w", err)
+	}
+	return results, nil
+}
+
+// GetSupplyForPixels returns the number of taxis made empty in each
+// superpixel, by the time they drop off their last passenger.
+func (db *sqlDB) GetSupplyForPixels(ctx context.Context, query SupplyDemandQuery) ([]SuperPixelSupply, error) {
+	var results []SuperPixelSupply
+	if err := db.supplyDemandQuery(ctx, query, "d", madeEmptyTime, &results); err != nil {
+		return nil, db.errorf("could not retrieve supply for pixels: %w", err)
+	}
+	return results, nil
+}
+
+func (db *sqlDB) GetNumTripsForCategory(ctx context.Context, category int) (int, error) {
+	var numTrips int
+	err := db.scanRow(ctx, db.conn.Model(&Passenger{}).Where("trip_category = ?", category).Select("COUNT(*)"), &numTrips)
+	if err != nil {
+		return 0, db.errorf("could not count trips of category %d: %w", category, err)
+	}
+	return numTrips, nil
+}
+
+func (db *sqlDB) GetCumulativeNumTripsForCategory(ctx context.Context, category int) (int, error) {
+	var numTrips int
+	err := db.scanRow(ctx, db.conn.Model(&Passenger{}).Where("trip_category <= ?", category).Select("COUNT(*)"), &numTrips)
+	if err != nil {
+		return 0, db.errorf("could not count trips of categories up to %d: %w", category, err)
+	}
+	return numTrips, nil
+}
+
+// hourOfDay returns the sql expression of the hour of the day of a time in
+// seconds.
+func (d sqlDialect) hourOfDay(t string) string {
+	return fmt.Sprintf("MOD(%s, 24)", d.div(t, 3600))
+}
+
+// avoGroupKey returns the sql expression taxis are grouped by for an AVO
+// grouping. A taxi's trip category is the category of its first passenger.
+func (d sqlDialect) avoGroupKey(groupBy string) (string, bool) {
+	switch groupBy {
+	case "all":
+		return "'all'", true
+	case "county":
+		return "o_fips", true
+	case "state":
+		return d.div("o_fips", 1000), true
+	case "hour":
+		return d.hourOfDay("departure_time"), true
+	case "time_category":
+		expr := "CASE"
+		for category, bound := range TimeCategoryBounds {
+			expr += fmt.Sprintf(" WHEN MOD(departure_time, %d) < %d THEN %d", SecondsPerDay, bound, category)
+		}
+		return expr + fmt.Sprintf(" ELSE %d END", len(TimeCategoryBounds)), true
+	case "trip_category":
+		return "(SELECT trip_category FROM passengers WHERE passengers.taxi_id = taxis.id ORDER BY passengers.id LIMIT 1)", true
+	}
+	return "", false
+}
+
+// GetAVOStats returns the AVO, PMT and VMT of the taxis matching filter,
+// grouped by one of AVOGroupings.
+func (db *sqlDB) GetAVOStats(ctx context.Context, groupBy string, filter TaxiFilter) ([]AVOStats, error) {
+	key, ok := db.dialect.avoGroupKey(groupBy)
+	if !ok {
+		return nil, db.errorf("cannot group AVO statistics by %q: %w", groupBy, ErrInvalidArgument)
+	}
+	var results []AVOStats
+	conn := whereTaxis(db.conn.Model(&Taxi{}), filter).
+		Select(key + " AS group_key, COUNT(*) AS num_taxis, SUM(num_passengers) AS num_passengers, " +
+			"SUM(pmt) AS pmt, SUM(vmt) AS vmt").
+		Group("group_key").Order("group_key")
+	err := db.find(ctx, conn, &results)
+	if err != nil {
+		return nil, db.errorf("could not compute AVO statistics: %w", err)
+	}
+	for i := range results {
+		results[i].fill(groupBy)
+	}
+	return results, nil
+}
+
+// supplyDemandGroupKeys returns the sql expressions taxis are grouped by for
+// the demand and supply totals of a grouping.
+func (d sqlDialect) supplyDemandGroupKeys(groupBy string) (string, string, bool) {
+	switch groupBy {
+	case "county":
+		return "o_fips", "d_fips", true
+	case "hour":
+		return d.hourOfDay("departure_time"), d.hourOfDay(madeEmptyTime), true
+	}
+	return "", "", false
+}
+
+// GetSupplyDemandByGroup returns the supply and demand of the taxis matching
+// filter, grouped by one of SupplyDemandGroupings.
+func (db *sqlDB) GetSupplyDemandByGroup(ctx context.Context, groupBy string, filter TaxiFilter) ([]GroupSupplyDemand, error) {
+	demandKey, supplyKey, ok := db.dialect.supplyDemandGroupKeys(groupBy)
+	if !ok {
+		return nil, db.errorf("cannot group supply and demand by %q: %w", groupBy, ErrInvalidArgument)
+	}
+	var demand, supply []GroupSupplyDemand
+	err := db.find(ctx, whereTaxis(db.conn.Model(&Taxi{}), filter).
+		Select(demandKey+" AS group_key, COUNT(*) AS demand").
+		Group("group_key"), &demand)
+	if err != nil {
+		return nil, db.errorf("could not compute demand: %w", err)
+	}
+	err = db.find(ctx, whereTaxis(db.conn.Model(&Taxi{}), filter).
+		Select(supplyKey+" AS group_key, COUNT(*) AS supply").
+		Group("group_key"), &supply)
+	if err != nil {
+		return nil, db.errorf("could not compute supply: %w", err)
+	}
+	return mergeSupplyDemand(demand, supply), nil
+}
+
+// ListScenarios returns the scenarios of the stored taxis, by name.
+func (db *sqlDB) ListScenarios(ctx context.Context) ([]ScenarioSummary, error) {
+	var results []ScenarioSummary
+	err := db.find(ctx, db.conn.Model(&Taxi{}).
+		Select("scenario AS name, COUNT(*) AS num_taxis, SUM(num_passengers) AS num_passengers").
+		Group("scenario").Order("scenario"), &results)
+	if err != nil {
+		return nil, db.errorf("could not list scenarios: %w", err)
+	}
+	return results, nil
+}
+
+// ListTaxisWithinRadius is only supported by PostGIS.
+func (db *sqlDB) ListTaxisWithinRadius(ctx context.Context, lat float64, lon float64, radius float64, filter TaxiFilter, limit int) ([]Taxi, error) {
+	return nil, db.errorf("%w", ErrSpatialUnsupported)
+}
+
+// ListTaxisWithinPolygon is only supported by PostGIS.
+func (db *sqlDB) ListTaxisWithinPolygon(ctx context.Context, polygon string, filter TaxiFilter, limit int) ([]Taxi, error) {
+	return nil, db.errorf("%w", ErrSpatialUnsupported)
+}
+
+// GetDemandForPolygons is only supported by PostGIS.
+func (db *sqlDB) GetDemandForPolygons(ctx context.Context, polygons []Polygon, filter TaxiFilter) ([]PolygonDemand, error) {
+	return nil, db.errorf("%w", ErrSpatialUnsupported)
+}
+
+// ListRuns returns the stored runs of region_avo and db_populate, newest
+// first.
+func (db *sqlDB) ListRuns(ctx context.Context) ([]Run, error) {
+	var runs []Run
+	if err := db.find(ctx, db.conn.Model(&Run{}).Order("id desc"), &runs); err != nil {
+		return nil, db.errorf("could not list runs: %w", err)
+	}
+	for i := range runs {
+		runs[i].fill()
+	}
+	return runs, nil
+}
+
+// runID resolves a runID of 0 to the latest run, and checks that other runs
+// exist.
+func (db *sqlDB) runID(ctx context.Context, runID uint) (uint, error) {
+	var run Run
+	query := db.conn.Model(&Run{}).Select("id")
+	if runID == 0 {
+		query = query.Order("id desc")
+	} else {
+		query = query.Where("id = ?", runID)
+	}
+	err := db.first(ctx, query, &run)
+	if gorm.IsRecordNotFoundError(err) {
+		if runID == 0 {
+			return 0, db.errorf("could not find any run: %w", ErrNotFound)
+		}
+		return 0, db.errorf("could not find run with id %d: %w", runID, ErrNotFound)
+	} else if err != nil {
+		return 0, db.errorf("could not find run: %w", err)
+	}
+	return run.ID, nil
+}
+
+// GetCountySummaries returns the county summaries of a run, by FIPS code. A
+// runID of 0 is the latest run.
+func (db *sqlDB) GetCountySummaries(ctx context.Context, runID uint) ([]CountySummary, error) {
+	runID, err := db.runID(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	var summaries []CountySummary
+	if err := db.find(ctx, db.conn.Model(&CountySummary{}).Where("run_id = ?", runID).Order("fips"), &summaries); err != nil {
+		return nil, db.errorf("could not retrieve county summaries of run %d: %w", runID, err)
+	}
+	for i := range summaries {
+		summaries[i].fill()
+	}
+	return summaries, nil
+}
+
+// GetStateSummaries returns the state summaries of a run, by FIPS code. A
+// runID of 0 is the latest run.
+func (db *sqlDB) GetStateSummaries(ctx context.Context, runID uint) ([]StateSummary, error) {
+	runID, err := db.runID(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	var summaries []StateSummary
+	if err := db.find(ctx, db.conn.Model(&StateSummary{}).Where("run_id = ?", runID).Order("fips"), &summaries); err != nil {
+		return nil, db.errorf("could not retrieve state summaries of run %d: %w", runID, err)
+	}
+	for i := range summaries {
+		summaries[i].fill()
+	}
+	return summaries, nil
+}
+
+// DatasetVersion returns a stamp that changes whenever the taxis and
+// passengers are reloaded or a run is stored: the version the loaders bump in
+// the dataset_versions row.
+func (db *sqlDB) DatasetVersion(ctx context.Context) (string, error) {
+	var version uint64
+	if err := db.scanRow(ctx, db.conn.Model(&DatasetVersion{}).Select("version").Where("id = 1"), &version); err != nil {
+		return "", db.errorf("could not retrieve dataset version: %w", err)
+	}
+	return strconv.FormatUint(version, 10), nil
+}
+
+// SchemaVersion returns the version of the last applied schema migration.
+func (db *sqlDB) SchemaVersion(ctx context.Context) (uint, error) {
+	version, err := AppliedSchemaVersion(db.conn)
+	if err != nil {
+		return 0, db.errorf("%w", err)
+	}
+	return version, nil
+}
+
+// Ping checks that the database is reachable.
+func (db *sqlDB) Ping(ctx context.Context) error {
+	if err := db.conn.DB().PingContext(ctx); err != nil {
+		return db.errorf("could not reach database: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database, freeing up any available resources.
+func (db *sqlDB) Close() {
+	db.conn.Close()
+}
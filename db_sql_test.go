@@ -0,0 +1,40 @@
+package ataxi
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+// unconnected is a gorm.SQLCommon that is never queried.
+type unconnected struct {
+	gorm.SQLCommon
+}
+
+func TestQuerySQL(t *testing.T) {
+	tests := []struct {
+		dialect string
+		want    string
+	}{
+		{"postgres", `SELECT * FROM "taxis"  WHERE (scenario = $1) AND (id IN ($2,$3)) ORDER BY departure_time asc, id asc LIMIT 5`},
+		{"mysql", "SELECT * FROM `taxis`  WHERE (scenario = ?) AND (id IN (?,?)) ORDER BY departure_time asc, id asc LIMIT 5"},
+	}
+	for _, test := range tests {
+		conn, err := gorm.Open(test.dialect, unconnected{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		query, args, err := querySQL(conn.Model(&Taxi{}).Where("scenario = ?", "base").
+			Where("id IN (?)", []uint{1, 2}).Order("departure_time asc, id asc").Limit(5))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query != test.want {
+			t.Errorf("%s: got %q, want %q", test.dialect, query, test.want)
+		}
+		if fmt.Sprint(args) != "[base 1 2]" {
+			t.Errorf("%s: got arguments %v, want [base 1 2]", test.dialect, args)
+		}
+	}
+}
@@ -4,10 +4,16 @@ import (
 	"bufio"
 	"encoding/csv"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -15,7 +21,7 @@ import (
 	"github.com/webapps/ataxi"
 )
 
-func handlePassenger(db *gorm.DB, taxis []*ataxi.Taxi, potentialTaxis []*ataxi.Taxi,
+func handlePassenger(taxis []*ataxi.Taxi, potentialTaxis []*ataxi.Taxi,
 	passenger *ataxi.Passenger, maxOccupancy uint32) ([]*ataxi.Taxi, []*ataxi.Taxi) {
 	var availableTaxis []*ataxi.Taxi
 	for _, taxi := range potentialTaxis {
@@ -50,30 +56,107 @@ func handlePassenger(db *gorm.DB, taxis []*ataxi.Taxi, potentialTaxis []*ataxi.T
 	return taxis, potentialTaxis
 }
 
-func main() {
-	if len(os.Args) != 2 {
-		log.Fatal(errors.New("You must provide a csv file."))
-		os.Exit(1)
-	}
+// dataDir is where trip files given by a relative path that does not exist
+// are looked up, as db_populate used to take file names in it.
+const dataDir = "../data"
+
+// tripFile is a trip file to load, and the source its taxis and passengers
+// are recorded under: its path relative to dataDir if it is in it, else
+// relative to the working directory if it is in it, else its absolute path.
+type tripFile struct {
+	path   string
+	source string
+}
 
-	db, err := gorm.Open("mysql", fmt.Sprintf("%s:%s@tcp(127.0.0.1:3306)/%s?charset=utf8&parseTime=True&loc=Local", ataxi.Config.Username, ataxi.Config.Password, ataxi.Config.Database))
+// sourceOf returns the source a trip file is recorded under, and its absolute
+// path.
+func sourceOf(path string) (source string, abs string, err error) {
+	abs, err = filepath.Abs(path)
 	if err != nil {
-		log.Fatal(err)
-		os.Exit(1)
+		return "", "", err
 	}
-	defer db.Close()
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", "", err
+	}
+	for _, dir := range []string{filepath.Join(wd, dataDir), wd} {
+		rel, err := filepath.Rel(dir, abs)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(rel), abs, nil
+		}
+	}
+	return filepath.ToSlash(abs), abs, nil
+}
 
-	if !db.HasTable(&ataxi.Passenger{}) && !db.HasTable(&ataxi.Taxi{}) {
-		db.AutoMigrate(&ataxi.Passenger{}, &ataxi.Taxi{})
+// inputFiles expands the command line arguments into trip files. Each argument
+// is a file, a directory whose csv files are all loaded, or a glob. Two
+// different files recorded under the same source are an error, as loading one
+// would replace the taxis and passengers of the other.
+func inputFiles(args []string) ([]tripFile, error) {
+	sources := make(map[string]string)
+	var files []tripFile
+	for _, arg := range args {
+		var matches []string
+		var err error
+		if strings.ContainsAny(arg, "*?[") {
+			matches, err = filepath.Glob(arg)
+			if err == nil && len(matches) == 0 && !filepath.IsAbs(arg) {
+				matches, err = filepath.Glob(filepath.Join(dataDir, arg))
+			}
+		} else {
+			path := arg
+			info, statErr := os.Stat(path)
+			if os.IsNotExist(statErr) && !filepath.IsAbs(arg) {
+				path = filepath.Join(dataDir, arg)
+				info, statErr = os.Stat(path)
+			}
+			if statErr != nil {
+				return nil, statErr
+			}
+			if info.IsDir() {
+				matches, err = filepath.Glob(filepath.Join(path, "*.csv"))
+			} else {
+				matches = []string{path}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no trip files match %s", arg)
+		}
+		for _, match := range matches {
+			source, abs, err := sourceOf(match)
+			if err != nil {
+				return nil, err
+			}
+			if other, ok := sources[source]; ok {
+				if other == abs {
+					continue
+				}
+				return nil, fmt.Errorf("%s and %s would both be recorded as source %s", other, abs, source)
+			}
+			sources[source] = abs
+			files = append(files, tripFile{path: match, source: source})
+		}
 	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files, nil
+}
 
-	csvFileName := os.Args[1]
-	csvFile, _ := os.Open(fmt.Sprintf("../data/%s", csvFileName))
+// readTaxis groups the passengers of a trip file into taxis. Taxis and
+// passengers are numbered from 1 within the file.
+func readTaxis(path string) (taxis []*ataxi.Taxi, rows int, numPassengers int, err error) {
+	csvFile, err := os.Open(path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer csvFile.Close()
 	reader := csv.NewReader(bufio.NewReader(csvFile))
+	if _, err := reader.Read(); err != nil {
+		return nil, 0, 0, fmt.Errorf("could not read header of %s: %v", path, err)
+	}
 
-	start := time.Now()
-	fmt.Println("Reading trip csv...")
-	var taxis []*ataxi.Taxi
 	var potentialTaxis []*ataxi.Taxi
 	var id uint
 	for {
@@ -81,8 +164,9 @@ func main() {
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			log.Fatal(err)
+			return nil, rows, 0, fmt.Errorf("could not read %s: %v", path, err)
 		}
+		rows++
 		row := ataxi.ParseLine(line)
 		passenger := ataxi.NewPassengerFromRow(id+1, row)
 		if passenger.TripCategory == 0 {
@@ -90,33 +174,266 @@ func main() {
 		}
 		id++
 
-		taxis, potentialTaxis = handlePassenger(db, taxis, potentialTaxis, passenger, 5)
-	}
-    for _, taxi := range potentialTaxis {
-        taxi.PMT = taxi.PersonMilesTraveled()
-        taxi.VMT = taxi.VehicleMilesTraveled()
-    }
-
-	fmt.Printf("Num of Taxis needed: %d\n", len(taxis))
-	elapsed := time.Since(start)
-	fmt.Printf("csv processing took %s\n", elapsed)
-	start = time.Now()
-	var pmt float64
-	var vmt float64
-	var numPassengers uint32
-	for i, taxi := range taxis {
-		db.Create(taxi)
-		pmt += taxi.PMT
-		vmt += taxi.VMT
-		numPassengers += taxi.NumPassengers
-		if i%10000 == 0 {
-			fmt.Printf("\rProcessed %d taxi(s)", i)
+		taxis, potentialTaxis = handlePassenger(taxis, potentialTaxis, passenger, 5)
+	}
+	for _, taxi := range potentialTaxis {
+		taxi.PMT = taxi.PersonMilesTraveled()
+		taxi.VMT = taxi.VehicleMilesTraveled()
+	}
+	return taxis, rows, int(id), nil
+}
+
+// fileLoad is the outcome of loading a trip file.
+type fileLoad struct {
+	source     string
+	countyFIPS uint32
+	rows       int
+	taxis      int
+	passengers uint32
+	pmt        float64
+	vmt        float64
+	elapsed    time.Duration
+	err        error
+}
+
+// progress prints how many of the taxis read so far were inserted, on a line
+// that is rewritten after each batch.
+type progress struct {
+	mu       sync.Mutex
+	start    time.Time
+	read     int
+	inserted int
+	pending  bool
+}
+
+// add counts taxis read and inserted, and rewrites the progress line when some
+// were inserted.
+func (p *progress) add(read, inserted int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.read += read
+	p.inserted += inserted
+	if inserted > 0 {
+		rate := float64(p.inserted) / time.Since(p.start).Seconds()
+		fmt.Printf("\rInserted %d/%d taxi(s) (%.0f taxis/s)", p.inserted, p.read, rate)
+		p.pending = true
+	}
+}
+
+// printf prints a line below the progress line.
+func (p *progress) printf(format string, a ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending {
+		fmt.Println()
+		p.pending = false
+	}
+	fmt.Printf(format, a...)
+}
+
+// loader loads trip files into a scenario.
+type loader struct {
+	db        *gorm.DB
+	scenario  string
+	batchSize int
+	progress  *progress
+}
+
+// load reads a trip file and stores its taxis and passengers, replacing any
+// loaded from the same file into the scenario before. The file is replaced in
+// a single transaction, so that a failed load leaves the previous one in place
+// and can be run again without duplicating it.
+func (l *loader) load(file tripFile) fileLoad {
+	start := time.Now()
+	source := file.source
+	result := fileLoad{source: source}
+	taxis, rows, numPassengers, err := readTaxis(file.path)
+	result.rows = rows
+	if err != nil {
+		result.err = err
+		return result
+	}
+	l.progress.add(len(taxis), 0)
+
+	// The taxis and passengers are numbered from 1; offset them into ids
+	// reserved in the database, which other loads will not use.
+	firstTaxiID, err := ataxi.ReserveIDs(l.db, "taxis", len(taxis))
+	if err != nil {
+		result.err = err
+		return result
+	}
+	firstPassengerID, err := ataxi.ReserveIDs(l.db, "passengers", numPassengers)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	taxiIDOffset, passengerIDOffset := firstTaxiID-1, firstPassengerID-1
+	for _, taxi := range taxis {
+		taxi.ID += taxiIDOffset
+		taxi.Scenario = l.scenario
+		taxi.Source = source
+		for j := range taxi.Passengers {
+			taxi.Passengers[j].ID += passengerIDOffset
+			taxi.Passengers[j].Scenario = l.scenario
+			taxi.Passengers[j].Source = source
+		}
+		result.pmt += taxi.PMT
+		result.vmt += taxi.VMT
+		result.passengers += taxi.NumPassengers
+	}
+
+	tx := l.db.Begin()
+	if tx.Error != nil {
+		result.err = tx.Error
+		return result
+	}
+	deleted, err := ataxi.DeleteSource(tx, l.scenario, source, l.batchSize)
+	if err != nil {
+		tx.Rollback()
+		result.err = err
+		return result
+	}
+	for i := 0; i < len(taxis); i += l.batchSize {
+		end := i + l.batchSize
+		if end > len(taxis) {
+			end = len(taxis)
 		}
+		if err := ataxi.InsertTaxis(tx, taxis[i:end]); err != nil {
+			tx.Rollback()
+			result.err = err
+			return result
+		}
+		l.progress.add(0, end-i)
+	}
+	if err := tx.Commit().Error; err != nil {
+		result.err = err
+		return result
+	}
+	if deleted > 0 {
+		l.progress.printf("%s: replaced %d taxi(s) of a previous load\n", source, deleted)
+	}
+	result.taxis = len(taxis)
+	// Bump the dataset version so the app drops its cached aggregates.
+	if err := ataxi.BumpDatasetVersion(l.db); err != nil {
+		result.err = err
+		return result
+	}
+
+	result.countyFIPS, _ = ataxi.FIPSFromFilename(filepath.Base(source))
+	if len(taxis) > 0 && taxis[0].OFIPS != 0 {
+		result.countyFIPS = taxis[0].OFIPS
+	}
+	result.elapsed = time.Since(start)
+	return result
+}
+
+func main() {
+	metricsFile := flag.String("metrics-file", "",
+		"write county metrics in the Prometheus text format to this file")
+	pushgateway := flag.String("pushgateway", "", "push county metrics to this Prometheus Pushgateway URL")
+	scenario := flag.String("scenario", ataxi.DefaultScenario,
+		"name of the simulation run the taxis and passengers are stored under")
+	batchSize := flag.Int("batch-size", 1000, "number of taxis inserted per statement batch")
+	workers := flag.Int("workers", runtime.NumCPU(), "number of files loaded concurrently")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		log.Fatal(errors.New("You must provide csv files, directories or globs."))
+	}
+	if *batchSize < 1 || *workers < 1 {
+		log.Fatal(errors.New("The batch size and number of workers must be positive."))
+	}
+	files, err := inputFiles(flag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config, err := ataxi.LoadConfig("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := gorm.Open(config.DBDriver, config.DataSourceName())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	schemaVersion, err := ataxi.AppliedSchemaVersion(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ataxi.CheckSchemaVersion(schemaVersion); err != nil {
+		log.Fatal(err)
+	}
+	start := time.Now()
+	l := &loader{db: db, scenario: *scenario, batchSize: *batchSize, progress: &progress{start: start}}
+	fmt.Printf("Loading %d trip file(s) into scenario %s...\n", len(files), *scenario)
+	queue := make(chan tripFile)
+	results := make(chan fileLoad)
+	var wg sync.WaitGroup
+	for i := 0; i < *workers && i < len(files); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range queue {
+				results <- l.load(file)
+			}
+		}()
+	}
+	go func() {
+		for _, file := range files {
+			queue <- file
+		}
+		close(queue)
+		wg.Wait()
+		close(results)
+	}()
+
+	metrics := ataxi.NewBatchMetrics("db_populate")
+	var total fileLoad
+	var failed []string
+	var counties []ataxi.CountySummary
+	for result := range results {
+		if result.err != nil {
+			l.progress.printf("%s: failed: %v\n", result.source, result.err)
+			failed = append(failed, result.source)
+			continue
+		}
+		metrics.ObserveCounty(result.countyFIPS, result.rows, result.taxis, result.elapsed)
+		l.progress.printf("%s: %d taxi(s), %d passenger(s), AVO %.3f, took %s\n",
+			result.source, result.taxis, result.passengers, ataxi.AVO(result.pmt, result.vmt), result.elapsed)
+		total.taxis += result.taxis
+		total.passengers += result.passengers
+		total.pmt += result.pmt
+		total.vmt += result.vmt
+		counties = append(counties, ataxi.CountySummary{
+			FIPS:          result.countyFIPS,
+			Rows:          result.rows,
+			NumTaxis:      result.taxis,
+			NumPassengers: int(result.passengers),
+			PMT:           result.pmt,
+			VMT:           result.vmt,
+		})
+	}
+
+	l.progress.printf("Finished inserting %d taxi(s) and %d passenger(s) in %s\n",
+		total.taxis, total.passengers, time.Since(start))
+	if total.taxis > 0 {
+		fmt.Printf("Capacity ratio: %f\n", float64(total.passengers)/float64(total.taxis))
+	}
+	fmt.Printf("AVO: %f\n", ataxi.AVO(total.pmt, total.vmt))
+	if len(failed) > 0 {
+		fmt.Printf("Not saving the county summaries, as %d file(s) failed to load\n", len(failed))
+	} else if len(counties) > 0 {
+		run := &ataxi.Run{Command: "db_populate", Scenario: *scenario, Source: strings.Join(flag.Args(), " ")}
+		if err := ataxi.SaveRun(db, run, counties); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Saved the county summaries as run %d\n", run.ID)
+	}
+	if err := metrics.Dump(*metricsFile, *pushgateway); err != nil {
+		log.Fatal(err)
+	}
+	if len(failed) > 0 {
+		log.Fatalf("%d file(s) failed to load, run again to retry them: %s", len(failed), strings.Join(failed, " "))
 	}
-	fmt.Println()
-	fmt.Printf("\rFinished processing %d taxi(s)\n", len(taxis))
-	fmt.Printf("Capacity ratio: %f\n", float64(numPassengers)/float64(len(taxis)))
-	fmt.Printf("AVO: %f\n", pmt/vmt)
-	elapsed = time.Since(start)
-	fmt.Printf("AVO analysis took %s\n", elapsed)
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// inDir runs f with the working directory set to dir.
+func inDir(t *testing.T, dir string, f func()) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	f()
+}
+
+func TestInputFiles(t *testing.T) {
+	root := t.TempDir()
+	work := filepath.Join(root, "work")
+	for _, path := range []string{"data/06001.csv", "data/ca/06003.csv", "work/06001.csv", "work/sub/06005.csv"} {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"sub", "ca/*.csv"}, "[{../data/ca/06003.csv ca/06003.csv} {sub/06005.csv sub/06005.csv}]"},
+		{[]string{"../data/ca/06003.csv", "ca", filepath.Join(root, "data/ca/06003.csv")}, "[{../data/ca/06003.csv ca/06003.csv}]"},
+		{[]string{"06001.csv"}, "[{06001.csv 06001.csv}]"},
+		{[]string{"06001.csv", "../data/06001.csv"}, "error"},
+		{[]string{"*.csv", "../data"}, "error"},
+		{[]string{"missing/*.csv"}, "error"},
+	}
+	inDir(t, work, func() {
+		for _, test := range tests {
+			files, err := inputFiles(test.args)
+			got := "error"
+			if err == nil {
+				got = fmt.Sprint(files)
+			}
+			if got != test.want {
+				t.Errorf("inputFiles(%q) = %s, want %s", test.args, got, test.want)
+			}
+		}
+	})
+}
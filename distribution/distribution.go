@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	geo "github.com/kellydunn/golang-geo"
+	"github.com/webapps/ataxi"
+)
+
+// distribution holds one histogram per measure, grouping and group key.
+type distribution struct {
+	bins       map[string]ataxi.Bins
+	histograms map[[3]string]*ataxi.Histogram
+}
+
+func newDistribution(bins map[string]ataxi.Bins) *distribution {
+	return &distribution{
+		bins:       bins,
+		histograms: make(map[[3]string]*ataxi.Histogram),
+	}
+}
+
+// add records value for measure under the "all" group and every given
+// grouping, passed as alternating grouping names and keys.
+func (d *distribution) add(measure string, value float64, groups ...string) {
+	d.histogram(measure, "all", "all").Add(value, 1)
+	for i := 0; i+1 < len(groups); i += 2 {
+		d.histogram(measure, groups[i], groups[i+1]).Add(value, 1)
+	}
+}
+
+func (d *distribution) histogram(measure string, group string, key string) *ataxi.Histogram {
+	id := [3]string{measure, group, key}
+	h, ok := d.histograms[id]
+	if !ok {
+		h = ataxi.NewHistogram(d.bins[measure])
+		d.histograms[id] = h
+	}
+	return h
+}
+
+func (d *distribution) write(w *csv.Writer) {
+	var ids [][3]string
+	for id := range d.histograms {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		for k := range ids[i] {
+			if ids[i][k] != ids[j][k] {
+				return ids[i][k] < ids[j][k]
+			}
+		}
+		return false
+	})
+
+	w.Write([]string{"Measure", "Group", "Key", "Name", "BinStart", "BinEnd", "Count",
+		"Cumulative", "PDF", "CDF"})
+	var row [10]string
+	for _, id := range ids {
+		h := d.histograms[id]
+		var name string
+		if id[1] == "state" {
+			fips, _ := strconv.ParseUint(id[2], 10, 32)
+			state, _ := ataxi.GetState(uint32(fips))
+			name = state.Name
+		}
+		cumulative := h.Cumulative()
+		pdf := h.PDF()
+		cdf := h.CDF()
+		row[0], row[1], row[2], row[3] = id[0], id[1], id[2], name
+		if h.Underflow > 0 {
+			row[4] = "-inf"
+			row[5] = strconv.FormatFloat(h.Bins[0], 'f', -1, 64)
+			row[6] = strconv.FormatFloat(h.Underflow, 'f', -1, 64)
+			row[7] = row[6]
+			row[8] = strconv.FormatFloat(h.Underflow/h.Total, 'f', 6, 64)
+			row[9] = row[8]
+			w.Write(row[:])
+		}
+		for i, count := range h.Counts {
+			lo, hi := h.Bins.Bounds(i)
+			row[4] = strconv.FormatFloat(lo, 'f', -1, 64)
+			row[5] = strconv.FormatFloat(hi, 'f', -1, 64)
+			if math.IsInf(hi, 1) {
+				row[5] = "inf"
+			}
+			row[6] = strconv.FormatFloat(count, 'f', -1, 64)
+			row[7] = strconv.FormatFloat(cumulative[i], 'f', -1, 64)
+			row[8] = strconv.FormatFloat(pdf[i], 'f', 6, 64)
+			row[9] = strconv.FormatFloat(cdf[i], 'f', 6, 64)
+			w.Write(row[:])
+		}
+	}
+}
+
+func stateKey(fips uint32) string {
+	return ataxi.FormatStateFIPS(ataxi.StateFIPS(fips))
+}
+
+// processPersonTrips adds the trip length of every person trip in the modal
+// person trip files to d.
+func processPersonTrips(d *distribution, files []string) int {
+	var counter int
+	for _, file := range files {
+		_, filename := filepath.Split(file)
+		fmt.Printf("Processing %s\n", filename)
+		csvFile, err := os.Open(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reader := csv.NewReader(bufio.NewReader(csvFile))
+		if _, err := reader.Read(); err != nil {
+			log.Fatal(err)
+		}
+		for {
+			line, err := reader.Read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				log.Fatal(err)
+			}
+			row := ataxi.ParseLine(line)
+			tripDistance := ataxi.GetTripDistance(geo.NewPoint(row.OLat, row.OLon),
+				geo.NewPoint(row.DLat, row.DLon))
+			d.add("TripLength", tripDistance,
+				"category", strconv.Itoa(int(ataxi.GetTripCategory(tripDistance))),
+				"otype", string(row.OType),
+				"state", stateKey(row.OFIPS))
+			counter++
+		}
+		csvFile.Close()
+	}
+	return counter
+}
+
+// processTaxiTrips adds the VMT, PMT and departure occupancy of every vehicle
+// trip in an ataxi trips csv generated by region_avo to d. Origin type is not
+// known for vehicle trips, so they are only grouped by category and state.
+func processTaxiTrips(d *distribution, file string) int {
+	csvFile, err := os.Open(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer csvFile.Close()
+	reader := csv.NewReader(bufio.NewReader(csvFile))
+	reader.FieldsPerRecord = -1
+	if _, err := reader.Read(); err != nil {
+		log.Fatal(err)
+	}
+	var counter int
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		vmt, _ := strconv.ParseFloat(line[6], 64)
+		occupancy, _ := strconv.ParseFloat(line[7], 64)
+		pmt, _ := strconv.ParseFloat(line[8], 64)
+
+		// Trip files written before the OFIPS and TripCategory columns
+		// existed only contribute to the "all" group.
+		var groups []string
+		if len(line) > 18 {
+			fips, _ := strconv.ParseUint(line[17], 10, 32)
+			groups = []string{"category", line[18], "state", stateKey(uint32(fips))}
+		}
+		d.add("VMT", vmt, groups...)
+		d.add("PMT", pmt, groups...)
+		d.add("Occupancy", occupancy, groups...)
+		counter++
+		if counter%10000 == 0 {
+			fmt.Printf("\rProcessed %d records", counter)
+		}
+	}
+	fmt.Println()
+	return counter
+}
+
+func main() {
+	binsSpec := flag.String("bins", "linear:0,400,400",
+		"trip length bin edges in miles: linear:min,max,n, log:min,max,n or a custom list of edges")
+	occupancyBinsSpec := flag.String("occupancy-bins", "1,2,3,4,5",
+		"departure occupancy bin edges, used with -ataxi")
+	taxiTrips := flag.Bool("ataxi", false,
+		"read a generated ataxi trips csv instead of a directory of modal person trip files")
+	output := flag.String("o", "", "output csv (default ../data/trip_length_distribution.csv, "+
+		"or ../data/ataxi_trip_distribution.csv with -ataxi)")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		log.Fatal("You must provide a data directory containing the ataxi mode trip files, " +
+			"or the generated ataxi region trips csv with -ataxi.")
+	}
+
+	bins, err := ataxi.ParseBins(*binsSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	occupancyBins, err := ataxi.ParseBins(*occupancyBinsSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	d := newDistribution(map[string]ataxi.Bins{
+		"TripLength": bins,
+		"VMT":        bins,
+		"PMT":        bins,
+		"Occupancy":  occupancyBins,
+	})
+
+	start := time.Now()
+	var counter int
+	outputFile := *output
+	if *taxiTrips {
+		fmt.Println("Processing provided ataxi trip file ...")
+		counter = processTaxiTrips(d, flag.Arg(0))
+		if outputFile == "" {
+			outputFile = "../data/ataxi_trip_distribution.csv"
+		}
+	} else {
+		files, err := filepath.Glob(filepath.Join(flag.Arg(0), "*.csv"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		counter = processPersonTrips(d, files)
+		if outputFile == "" {
+			outputFile = "../data/trip_length_distribution.csv"
+		}
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("Processed %d trips in %s\n", counter, elapsed)
+
+	fmt.Printf("Creating %s\n", outputFile)
+	file, err := os.Create(outputFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	writer := csv.NewWriter(file)
+	d.write(writer)
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Fatal(err)
+	}
+	file.Close()
+	fmt.Println("Finished")
+}
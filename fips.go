@@ -0,0 +1,134 @@
+package ataxi
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var fipsFilenameRegexp = regexp.MustCompile("[0-9]{5}")
+
+// StateFIPS returns the two digit state part of a five digit county FIPS code.
+func StateFIPS(countyFIPS uint32) uint32 {
+	return countyFIPS / 1000
+}
+
+// FormatCountyFIPS formats a county FIPS code with its leading zeros.
+func FormatCountyFIPS(countyFIPS uint32) string {
+	return fmt.Sprintf("%05d", countyFIPS)
+}
+
+// FormatStateFIPS formats a state FIPS code with its leading zero.
+func FormatStateFIPS(stateFIPS uint32) string {
+	return fmt.Sprintf("%02d", stateFIPS)
+}
+
+// FIPSFromFilename returns the county FIPS code in the name of a modal person
+// trip file, e.g. "34021.csv" or "NJ34021trips.csv".
+func FIPSFromFilename(filename string) (uint32, bool) {
+	match := fipsFilenameRegexp.FindString(filename)
+	if match == "" {
+		return 0, false
+	}
+	fips, err := strconv.ParseUint(match, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(fips), true
+}
+
+// State identifies a state or territory by its FIPS code.
+type State struct {
+	FIPS         uint32
+	Abbreviation string
+	Name         string
+}
+
+// County identifies a county or county equivalent by its FIPS code.
+type County struct {
+	FIPS  uint32
+	Name  string
+	State State
+}
+
+var states = []State{
+	{1, "AL", "Alabama"}, {2, "AK", "Alaska"}, {4, "AZ", "Arizona"},
+	{5, "AR", "Arkansas"}, {6, "CA", "California"}, {8, "CO", "Colorado"},
+	{9, "CT", "Connecticut"}, {10, "DE", "Delaware"}, {11, "DC", "District of Columbia"},
+	{12, "FL", "Florida"}, {13, "GA", "Georgia"}, {15, "HI", "Hawaii"},
+	{16, "ID", "Idaho"}, {17, "IL", "Illinois"}, {18, "IN", "Indiana"},
+	{19, "IA", "Iowa"}, {20, "KS", "Kansas"}, {21, "KY", "Kentucky"},
+	{22, "LA", "Louisiana"}, {23, "ME", "Maine"}, {24, "MD", "Maryland"},
+	{25, "MA", "Massachusetts"}, {26, "MI", "Michigan"}, {27, "MN", "Minnesota"},
+	{28, "MS", "Mississippi"}, {29, "MO", "Missouri"}, {30, "MT", "Montana"},
+	{31, "NE", "Nebraska"}, {32, "NV", "Nevada"}, {33, "NH", "New Hampshire"},
+	{34, "NJ", "New Jersey"}, {35, "NM", "New Mexico"}, {36, "NY", "New York"},
+	{37, "NC", "North Carolina"}, {38, "ND", "North Dakota"}, {39, "OH", "Ohio"},
+	{40, "OK", "Oklahoma"}, {41, "OR", "Oregon"}, {42, "PA", "Pennsylvania"},
+	{44, "RI", "Rhode Island"}, {45, "SC", "South Carolina"}, {46, "SD", "South Dakota"},
+	{47, "TN", "Tennessee"}, {48, "TX", "Texas"}, {49, "UT", "Utah"},
+	{50, "VT", "Vermont"}, {51, "VA", "Virginia"}, {53, "WA", "Washington"},
+	{54, "WV", "West Virginia"}, {55, "WI", "Wisconsin"}, {56, "WY", "Wyoming"},
+	{60, "AS", "American Samoa"}, {66, "GU", "Guam"}, {69, "MP", "Northern Mariana Islands"},
+	{72, "PR", "Puerto Rico"}, {78, "VI", "U.S. Virgin Islands"},
+}
+
+// statesByFIPS, like countyNames, is only written by init, so lookups are
+// safe from any goroutine.
+var statesByFIPS = make(map[uint32]State)
+
+func init() {
+	for _, state := range states {
+		statesByFIPS[state.FIPS] = state
+	}
+}
+
+// ListStates returns every state and territory, ordered by FIPS code.
+func ListStates() []State {
+	return append([]State(nil), states...)
+}
+
+// GetState returns the state with the given two digit FIPS code.
+func GetState(stateFIPS uint32) (State, bool) {
+	state, ok := statesByFIPS[stateFIPS]
+	return state, ok
+}
+
+// GetCounty returns the county with the given five digit FIPS code. The
+// county's state is filled in even when the county is unknown, in which case
+// ok is false.
+func GetCounty(countyFIPS uint32) (County, bool) {
+	state, _ := GetState(StateFIPS(countyFIPS))
+	name, ok := countyNames[countyFIPS]
+	return County{
+		FIPS:  countyFIPS,
+		Name:  name,
+		State: state,
+	}, ok
+}
+
+// ListCounties returns every known county, ordered by FIPS code.
+func ListCounties() []County {
+	var counties []County
+	for fips := range countyNames {
+		county, _ := GetCounty(fips)
+		counties = append(counties, county)
+	}
+	sort.Slice(counties, func(i, j int) bool { return counties[i].FIPS < counties[j].FIPS })
+	return counties
+}
+
+// StateColumns returns the abbreviation and name of a state for csv output.
+// Unknown states have empty columns.
+func StateColumns(stateFIPS uint32) []string {
+	state, _ := GetState(stateFIPS)
+	return []string{state.Abbreviation, state.Name}
+}
+
+// CountyColumns returns the name, state abbreviation and state name of a
+// county for csv output. Unknown names are left empty.
+func CountyColumns(countyFIPS uint32) []string {
+	county, _ := GetCounty(countyFIPS)
+	return []string{county.Name, county.State.Abbreviation, county.State.Name}
+}
@@ -0,0 +1,166 @@
+package ataxi
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Bins are the ascending edges of a histogram. Bin i covers
+// [edges[i], edges[i+1]); values at or past the last edge fall into a final
+// overflow bin and values below the first edge are underflow, outside of any
+// bin.
+type Bins []float64
+
+// LinearBins returns n equal width bins spanning [min, max).
+func LinearBins(min float64, max float64, n int) (Bins, error) {
+	if n < 1 || max <= min {
+		return nil, fmt.Errorf("invalid linear bins: min %g, max %g, n %d", min, max, n)
+	}
+	bins := make(Bins, n+1)
+	width := (max - min) / float64(n)
+	for i := range bins {
+		bins[i] = min + float64(i)*width
+	}
+	return bins, nil
+}
+
+// LogBins returns n logarithmically spaced bins spanning [min, max).
+func LogBins(min float64, max float64, n int) (Bins, error) {
+	if n < 1 || min <= 0 || max <= min {
+		return nil, fmt.Errorf("invalid log bins: min %g, max %g, n %d", min, max, n)
+	}
+	bins := make(Bins, n+1)
+	ratio := math.Log(max/min) / float64(n)
+	for i := range bins {
+		bins[i] = min * math.Exp(float64(i)*ratio)
+	}
+	bins[n] = max
+	return bins, nil
+}
+
+// ParseBins parses a bin specification. Supported forms are
+// "linear:min,max,n", "log:min,max,n" and a custom list of ascending edges
+// such as "0,0.5,10,100,400".
+func ParseBins(spec string) (Bins, error) {
+	kind := "custom"
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, spec = spec[:i], spec[i+1:]
+	}
+	var values []float64
+	for _, field := range strings.Split(spec, ",") {
+		value, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bin edge %q: %v", field, err)
+		}
+		values = append(values, value)
+	}
+	switch kind {
+	case "linear", "log":
+		if len(values) != 3 || values[2] != math.Trunc(values[2]) {
+			return nil, fmt.Errorf("%s bins must be given as %s:min,max,n", kind, kind)
+		}
+		if kind == "linear" {
+			return LinearBins(values[0], values[1], int(values[2]))
+		}
+		return LogBins(values[0], values[1], int(values[2]))
+	case "custom":
+		if len(values) < 2 {
+			return nil, errors.New("custom bins need at least two edges")
+		}
+		if !sort.Float64sAreSorted(values) {
+			return nil, errors.New("custom bin edges must be ascending")
+		}
+		return Bins(values), nil
+	}
+	return nil, fmt.Errorf("unknown bin type %q", kind)
+}
+
+// Len returns the number of bins, including the overflow bin.
+func (bins Bins) Len() int {
+	return len(bins)
+}
+
+// Index returns the bin a value falls into, or -1 if it is below the first
+// edge.
+func (bins Bins) Index(value float64) int {
+	// The first edge greater than value closes the bin value falls into.
+	i := sort.Search(len(bins), func(i int) bool { return bins[i] > value })
+	return i - 1
+}
+
+// Bounds returns the lower and upper edge of bin i. The upper edge of the
+// overflow bin is +Inf.
+func (bins Bins) Bounds(i int) (float64, float64) {
+	if i == len(bins)-1 {
+		return bins[i], math.Inf(1)
+	}
+	return bins[i], bins[i+1]
+}
+
+// Histogram accumulates weighted counts of values over a set of bins. The
+// weight of values below the first edge is kept in Underflow, and Total
+// includes it.
+type Histogram struct {
+	Bins      Bins
+	Counts    []float64
+	Underflow float64
+	Total     float64
+}
+
+// NewHistogram returns an empty histogram over bins.
+func NewHistogram(bins Bins) *Histogram {
+	return &Histogram{
+		Bins:   bins,
+		Counts: make([]float64, bins.Len()),
+	}
+}
+
+// Add records value with the given weight.
+func (h *Histogram) Add(value float64, weight float64) {
+	if i := h.Bins.Index(value); i >= 0 {
+		h.Counts[i] += weight
+	} else {
+		h.Underflow += weight
+	}
+	h.Total += weight
+}
+
+// Cumulative returns the running total of counts over the bins, starting
+// from the underflow.
+func (h *Histogram) Cumulative() []float64 {
+	cumulative := make([]float64, len(h.Counts))
+	sum := h.Underflow
+	for i, count := range h.Counts {
+		sum += count
+		cumulative[i] = sum
+	}
+	return cumulative
+}
+
+// PDF returns the fraction of the total weight in each bin.
+func (h *Histogram) PDF() []float64 {
+	pdf := make([]float64, len(h.Counts))
+	if h.Total == 0 {
+		return pdf
+	}
+	for i, count := range h.Counts {
+		pdf[i] = count / h.Total
+	}
+	return pdf
+}
+
+// CDF returns the fraction of the total weight in each bin and all bins below it.
+func (h *Histogram) CDF() []float64 {
+	cdf := h.Cumulative()
+	if h.Total == 0 {
+		return cdf
+	}
+	for i := range cdf {
+		cdf[i] /= h.Total
+	}
+	return cdf
+}
@@ -0,0 +1,89 @@
+package ataxi
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestParseBins(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Bins
+		wantErr bool
+	}{
+		{spec: "linear:0,10,5", want: Bins{0, 2, 4, 6, 8, 10}},
+		{spec: "log:1,100,2", want: Bins{1, 10, 100}},
+		{spec: "0, 0.5,10,400", want: Bins{0, 0.5, 10, 400}},
+		{spec: "linear:0,10", wantErr: true},
+		{spec: "linear:0,10,2.5", wantErr: true},
+		{spec: "linear:10,0,5", wantErr: true},
+		{spec: "log:0,100,2", wantErr: true},
+		{spec: "5", wantErr: true},
+		{spec: "0,10,5", wantErr: true},
+		{spec: "0,x", wantErr: true},
+		{spec: "cubic:0,10,5", wantErr: true},
+	}
+	for _, test := range tests {
+		bins, err := ParseBins(test.spec)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseBins(%q) = %v, want an error", test.spec, bins)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseBins(%q): %v", test.spec, err)
+			continue
+		}
+		// Log edges are computed, so compare them rounded.
+		if fmt.Sprintf("%.9g", bins) != fmt.Sprintf("%.9g", test.want) {
+			t.Errorf("ParseBins(%q) = %v, want %v", test.spec, bins, test.want)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	bins := Bins{0, 1, 10}
+	tests := []struct {
+		value      float64
+		weight     float64
+		wantIndex  int
+		wantCounts []float64
+		wantUnder  float64
+	}{
+		{value: 0, weight: 1, wantIndex: 0, wantCounts: []float64{1, 0, 0}},
+		{value: 0.5, weight: 2, wantIndex: 0, wantCounts: []float64{3, 0, 0}},
+		{value: 1, weight: 1, wantIndex: 1, wantCounts: []float64{3, 1, 0}},
+		{value: 10, weight: 1, wantIndex: 2, wantCounts: []float64{3, 1, 1}},
+		{value: 1000, weight: 1, wantIndex: 2, wantCounts: []float64{3, 1, 2}},
+		{value: -1, weight: 2, wantIndex: -1, wantCounts: []float64{3, 1, 2}, wantUnder: 2},
+	}
+	h := NewHistogram(bins)
+	for _, test := range tests {
+		if i := bins.Index(test.value); i != test.wantIndex {
+			t.Errorf("Index(%g) = %d, want %d", test.value, i, test.wantIndex)
+		}
+		h.Add(test.value, test.weight)
+		if fmt.Sprint(h.Counts) != fmt.Sprint(test.wantCounts) || h.Underflow != test.wantUnder {
+			t.Errorf("after adding %g: got counts %v and underflow %g, want %v and %g",
+				test.value, h.Counts, h.Underflow, test.wantCounts, test.wantUnder)
+		}
+	}
+
+	if h.Total != 8 {
+		t.Errorf("got total %g, want 8", h.Total)
+	}
+	if got, want := fmt.Sprint(h.Cumulative()), fmt.Sprint([]float64{5, 6, 8}); got != want {
+		t.Errorf("got cumulative %s, want %s", got, want)
+	}
+	if got, want := fmt.Sprint(h.PDF()), fmt.Sprint([]float64{0.375, 0.125, 0.25}); got != want {
+		t.Errorf("got PDF %s, want %s", got, want)
+	}
+	if got, want := fmt.Sprint(h.CDF()), fmt.Sprint([]float64{0.625, 0.75, 1}); got != want {
+		t.Errorf("got CDF %s, want %s", got, want)
+	}
+	if lo, hi := bins.Bounds(2); lo != 10 || !math.IsInf(hi, 1) {
+		t.Errorf("got overflow bounds %g, %g, want 10, +Inf", lo, hi)
+	}
+}
@@ -0,0 +1,250 @@
+package ataxi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// DBQueryDuration records the latency of every RideSharingDatabase method
+// called through an instrumented database.
+var DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "ataxi",
+	Name:      "db_query_duration_seconds",
+	Help:      "Latency of database queries by RideSharingDatabase method.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"method", "status"})
+
+// CacheRequests counts the aggregate queries answered by a cached database,
+// by whether the result was cached.
+var CacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ataxi",
+	Name:      "cache_requests_total",
+	Help:      "Aggregate queries answered by the query cache, by result (hit or miss).",
+}, []string{"result"})
+
+// instrumentedDB records the latency of every query of the wrapped database.
+type instrumentedDB struct {
+	db RideSharingDatabase
+}
+
+var _ RideSharingDatabase = &instrumentedDB{}
+
+// NewInstrumentedDB wraps db so that every query is recorded in
+// DBQueryDuration.
+func NewInstrumentedDB(db RideSharingDatabase) RideSharingDatabase {
+	return &instrumentedDB{db: db}
+}
+
+func observe(method string, start time.Time, err error) {
+	status := "ok"
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		status = "canceled"
+	} else if err != nil {
+		status = "error"
+	}
+	DBQueryDuration.WithLabelValues(method, status).Observe(time.Since(start).Seconds())
+}
+
+func (i *instrumentedDB) ListTaxis(ctx context.Context, orderBy string, limit int, withPassengers bool) (taxis []Taxi, err error) {
+	defer func(start time.Time) { observe("ListTaxis", start, err) }(time.Now())
+	return i.db.ListTaxis(ctx, orderBy, limit, withPassengers)
+}
+
+func (i *instrumentedDB) ListTaxisByDepartureTime(ctx context.Context, limit int, withPassengers bool) (taxis []Taxi, err error) {
+	defer func(start time.Time) { observe("ListTaxisByDepartureTime", start, err) }(time.Now())
+	return i.db.ListTaxisByDepartureTime(ctx, limit, withPassengers)
+}
+
+func (i *instrumentedDB) ListTaxisByNumPassengers(ctx context.Context, limit int, withPassengers bool) (taxis []Taxi, err error) {
+	defer func(start time.Time) { observe("ListTaxisByNumPassengers", start, err) }(time.Now())
+	return i.db.ListTaxisByNumPassengers(ctx, limit, withPassengers)
+}
+
+func (i *instrumentedDB) QueryTaxis(ctx context.Context, filter TaxiFilter, orderBy string, offset int, limit int, withPassengers bool) (taxis []Taxi, err error) {
+	defer func(start time.Time) { observe("QueryTaxis", start, err) }(time.Now())
+	return i.db.QueryTaxis(ctx, filter, orderBy, offset, limit, withPassengers)
+}
+
+func (i *instrumentedDB) StreamTaxis(ctx context.Context, filter TaxiFilter, orderBy string, offset int, limit int, withPassengers bool, fn func(*Taxi) error) (err error) {
+	defer func(start time.Time) { observe("StreamTaxis", start, err) }(time.Now())
+	return i.db.StreamTaxis(ctx, filter, orderBy, offset, limit, withPassengers, fn)
+}
+
+func (i *instrumentedDB) CountTaxis(ctx context.Context, filter TaxiFilter) (count int, err error) {
+	defer func(start time.Time) { observe("CountTaxis", start, err) }(time.Now())
+	return i.db.CountTaxis(ctx, filter)
+}
+
+func (i *instrumentedDB) GetTaxi(ctx context.Context, id uint) (taxi *Taxi, err error) {
+	defer func(start time.Time) { observe("GetTaxi", start, err) }(time.Now())
+	return i.db.GetTaxi(ctx, id)
+}
+
+func (i *instrumentedDB) ListPassengers(ctx context.Context, limit int) (passengers []Passenger, err error) {
+	defer func(start time.Time) { observe("ListPassengers", start, err) }(time.Now())
+	return i.db.ListPassengers(ctx, limit)
+}
+
+func (i *instrumentedDB) StreamPassengers(ctx context.Context, limit int, fn func(*Passenger) error) (err error) {
+	defer func(start time.Time) { observe("StreamPassengers", start, err) }(time.Now())
+	return i.db.StreamPassengers(ctx, limit, fn)
+}
+
+func (i *instrumentedDB) ListPassengersForTaxi(ctx context.Context, taxiID uint) (passengers []Passenger, err error) {
+	defer func(start time.Time) { observe("ListPassengersForTaxi", start, err) }(time.Now())
+	return i.db.ListPassengersForTaxi(ctx, taxiID)
+}
+
+func (i *instrumentedDB) GetPassenger(ctx context.Context, id uint) (passenger *Passenger, err error) {
+	defer func(start time.Time) { observe("GetPassenger", start, err) }(time.Now())
+	return i.db.GetPassenger(ctx, id)
+}
+
+func (i *instrumentedDB) GetDemandForPixels(ctx context.Context, query SupplyDemandQuery) (results []SuperPixelDemand, err error) {
+	defer func(start time.Time) { observe("GetDemandForPixels", start, err) }(time.Now())
+	return i.db.GetDemandForPixels(ctx, query)
+}
+
+func (i *instrumentedDB) GetSupplyForPixels(ctx context.Context, query SupplyDemandQuery) (results []SuperPixelSupply, err error) {
+	defer func(start time.Time) { observe("GetSupplyForPixels", start, err) }(time.Now())
+	return i.db.GetSupplyForPixels(ctx, query)
+}
+
+func (i *instrumentedDB) GetNumTripsForCategory(ctx context.Context, category int) (numTrips int, err error) {
+	defer func(start time.Time) { observe("GetNumTripsForCategory", start, err) }(time.Now())
+	return i.db.GetNumTripsForCategory(ctx, category)
+}
+
+func (i *instrumentedDB) GetCumulativeNumTripsForCategory(ctx context.Context, category int) (numTrips int, err error) {
+	defer func(start time.Time) { observe("GetCumulativeNumTripsForCategory", start, err) }(time.Now())
+	return i.db.GetCumulativeNumTripsForCategory(ctx, category)
+}
+
+func (i *instrumentedDB) GetAVOStats(ctx context.Context, groupBy string, filter TaxiFilter) (stats []AVOStats, err error) {
+	defer func(start time.Time) { observe("GetAVOStats", start, err) }(time.Now())
+	return i.db.GetAVOStats(ctx, groupBy, filter)
+}
+
+func (i *instrumentedDB) GetSupplyDemandByGroup(ctx context.Context, groupBy string, filter TaxiFilter) (groups []GroupSupplyDemand, err error) {
+	defer func(start time.Time) { observe("GetSupplyDemandByGroup", start, err) }(time.Now())
+	return i.db.GetSupplyDemandByGroup(ctx, groupBy, filter)
+}
+
+func (i *instrumentedDB) ListScenarios(ctx context.Context) (scenarios []ScenarioSummary, err error) {
+	defer func(start time.Time) { observe("ListScenarios", start, err) }(time.Now())
+	return i.db.ListScenarios(ctx)
+}
+
+func (i *instrumentedDB) ListTaxisWithinRadius(ctx context.Context, lat float64, lon float64, radius float64, filter TaxiFilter, limit int) (taxis []Taxi, err error) {
+	defer func(start time.Time) { observe("ListTaxisWithinRadius", start, err) }(time.Now())
+	return i.db.ListTaxisWithinRadius(ctx, lat, lon, radius, filter, limit)
+}
+
+func (i *instrumentedDB) ListTaxisWithinPolygon(ctx context.Context, polygon string, filter TaxiFilter, limit int) (taxis []Taxi, err error) {
+	defer func(start time.Time) { observe("ListTaxisWithinPolygon", start, err) }(time.Now())
+	return i.db.ListTaxisWithinPolygon(ctx, polygon, filter, limit)
+}
+
+func (i *instrumentedDB) GetDemandForPolygons(ctx context.Context, polygons []Polygon, filter TaxiFilter) (results []PolygonDemand, err error) {
+	defer func(start time.Time) { observe("GetDemandForPolygons", start, err) }(time.Now())
+	return i.db.GetDemandForPolygons(ctx, polygons, filter)
+}
+
+func (i *instrumentedDB) ListRuns(ctx context.Context) (runs []Run, err error) {
+	defer func(start time.Time) { observe("ListRuns", start, err) }(time.Now())
+	return i.db.ListRuns(ctx)
+}
+
+func (i *instrumentedDB) GetCountySummaries(ctx context.Context, runID uint) (summaries []CountySummary, err error) {
+	defer func(start time.Time) { observe("GetCountySummaries", start, err) }(time.Now())
+	return i.db.GetCountySummaries(ctx, runID)
+}
+
+func (i *instrumentedDB) GetStateSummaries(ctx context.Context, runID uint) (summaries []StateSummary, err error) {
+	defer func(start time.Time) { observe("GetStateSummaries", start, err) }(time.Now())
+	return i.db.GetStateSummaries(ctx, runID)
+}
+
+func (i *instrumentedDB) DatasetVersion(ctx context.Context) (version string, err error) {
+	defer func(start time.Time) { observe("DatasetVersion", start, err) }(time.Now())
+	return i.db.DatasetVersion(ctx)
+}
+
+func (i *instrumentedDB) SchemaVersion(ctx context.Context) (version uint, err error) {
+	defer func(start time.Time) { observe("SchemaVersion", start, err) }(time.Now())
+	return i.db.SchemaVersion(ctx)
+}
+
+func (i *instrumentedDB) Ping(ctx context.Context) (err error) {
+	defer func(start time.Time) { observe("Ping", start, err) }(time.Now())
+	return i.db.Ping(ctx)
+}
+
+func (i *instrumentedDB) Close() {
+	i.db.Close()
+}
+
+// BatchMetrics are the metrics recorded by a batch command, per county.
+type BatchMetrics struct {
+	Registry       *prometheus.Registry
+	RowsProcessed  *prometheus.CounterVec
+	TaxisCreated   *prometheus.CounterVec
+	ElapsedSeconds *prometheus.GaugeVec
+	job            string
+}
+
+// NewBatchMetrics returns the metrics of the batch command job.
+func NewBatchMetrics(job string) *BatchMetrics {
+	m := &BatchMetrics{
+		Registry: prometheus.NewRegistry(),
+		RowsProcessed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ataxi",
+			Name:      "rows_processed_total",
+			Help:      "Person trip rows processed, by county.",
+		}, []string{"county"}),
+		TaxisCreated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ataxi",
+			Name:      "taxis_created_total",
+			Help:      "aTaxi trips created, by county.",
+		}, []string{"county"}),
+		ElapsedSeconds: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ataxi",
+			Name:      "county_elapsed_seconds",
+			Help:      "Time spent processing a county.",
+		}, []string{"county"}),
+		job: job,
+	}
+	m.Registry.MustRegister(m.RowsProcessed, m.TaxisCreated, m.ElapsedSeconds)
+	return m
+}
+
+// ObserveCounty records the rows processed, taxis created and time spent for
+// a county.
+func (m *BatchMetrics) ObserveCounty(countyFIPS uint32, rows int, taxis int, elapsed time.Duration) {
+	county := FormatCountyFIPS(countyFIPS)
+	m.RowsProcessed.WithLabelValues(county).Add(float64(rows))
+	m.TaxisCreated.WithLabelValues(county).Add(float64(taxis))
+	m.ElapsedSeconds.WithLabelValues(county).Set(elapsed.Seconds())
+}
+
+// Dump writes the metrics in the Prometheus text format to textfile, for the
+// node exporter textfile collector, and pushes them to the Pushgateway at
+// pushURL. Either is skipped when empty.
+func (m *BatchMetrics) Dump(textfile string, pushURL string) error {
+	if textfile != "" {
+		if err := prometheus.WriteToTextfile(textfile, m.Registry); err != nil {
+			return fmt.Errorf("metrics: could not write %s: %v", textfile, err)
+		}
+	}
+	if pushURL != "" {
+		if err := push.New(pushURL, m.job).Gatherer(m.Registry).Push(); err != nil {
+			return fmt.Errorf("metrics: could not push to %s: %v", pushURL, err)
+		}
+	}
+	return nil
+}
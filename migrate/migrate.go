@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	"github.com/webapps/ataxi"
+)
+
+const usage = `usage: migrate [-config file] command [version]
+
+commands:
+  up [version]    apply the pending migrations, up to version if given
+  down [version]  revert the migrations newer than version, or the last one
+  status          list the migrations and whether they are applied
+`
+
+func main() {
+	configPath := flag.String("config", "", "path of the config file (default $"+ataxi.ConfigPathEnv+" or "+ataxi.DefaultConfigPath+")")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	command := flag.Arg(0)
+	var target *uint
+	if flag.NArg() == 2 {
+		version, err := strconv.ParseUint(flag.Arg(1), 10, 32)
+		if err != nil {
+			log.Fatal(fmt.Errorf("invalid version %q", flag.Arg(1)))
+		}
+		v := uint(version)
+		target = &v
+	}
+
+	config, err := ataxi.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := gorm.Open(config.DBDriver, config.DataSourceName())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	switch command {
+	case "up":
+		latest := ataxi.LatestSchemaVersion()
+		if target == nil {
+			target = &latest
+		}
+		migrations, err := ataxi.MigrateUp(db, *target)
+		for _, migration := range migrations {
+			fmt.Printf("Applied %d: %s\n", migration.Version, migration.Description)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(migrations) == 0 {
+			fmt.Println("No pending migrations")
+		}
+	case "down":
+		if target == nil {
+			version, err := ataxi.AppliedSchemaVersion(db)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if version == 0 {
+				fmt.Println("No applied migrations")
+				return
+			}
+			version--
+			target = &version
+		}
+		migrations, err := ataxi.MigrateDown(db, *target)
+		for _, migration := range migrations {
+			fmt.Printf("Reverted %d: %s\n", migration.Version, migration.Description)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "status":
+		if target != nil {
+			log.Fatal(errors.New("status takes no version"))
+		}
+		statuses, err := ataxi.MigrationStatuses(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied {
+				applied = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-27s  %s\n", status.Version, applied, status.Description)
+		}
+		version, err := ataxi.AppliedSchemaVersion(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Schema version %d of %d\n", version, ataxi.LatestSchemaVersion())
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,488 @@
+package ataxi
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Migration is a reversible change to the database schema. Migrations work on
+// copies of the models as they were at their version, so that later changes
+// to the models do not change what an old migration does.
+type Migration struct {
+	Version     uint
+	Description string
+	Up          func(db *gorm.DB) error
+	Down        func(db *gorm.DB) error
+}
+
+// SchemaMigration records an applied migration.
+type SchemaMigration struct {
+	Version     uint   `gorm:"primary_key;auto_increment:false"`
+	Description string `sql:"size:255"`
+	AppliedAt   time.Time
+}
+
+// MigrationStatus is a migration and when it was applied, if it was.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrations are the schema migrations, in version order. Append new ones;
+// never edit one that has been released.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create passengers and taxis tables",
+		Up: func(db *gorm.DB) error {
+			// AutoMigrate keeps tables created by db_populate before
+			// migrations existed, so that they can be adopted.
+			return db.AutoMigrate(&passengerV1{}, &taxiV1{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.DropTableIfExists(&passengerV1{}, &taxiV1{}).Error
+		},
+	},
+	{
+		Version:     2,
+		Description: "create dataset_versions table",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&datasetVersionV2{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.DropTableIfExists(&datasetVersionV2{}).Error
+		},
+	},
+	{
+		Version:     3,
+		Description: "add scenario to passengers and taxis",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&passengerScenarioV3{}, &taxiScenarioV3{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return dropIndexedColumn(db, "scenario", &passengerScenarioV3{}, &taxiScenarioV3{})
+		},
+	},
+	{
+		Version:     4,
+		Description: "add source to passengers and taxis",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&passengerSourceV4{}, &taxiSourceV4{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return dropIndexedColumn(db, "source", &passengerSourceV4{}, &taxiSourceV4{})
+		},
+	},
+	{
+		Version:     5,
+		Description: "add PostGIS origin and destination points to passengers and taxis",
+		Up: func(db *gorm.DB) error {
+			if db.Dialect().GetName() != "postgres" {
+				return nil
+			}
+			statements := []string{"CREATE EXTENSION IF NOT EXISTS postgis"}
+			for _, table := range []string{"passengers", "taxis"} {
+				for _, end := range []string{"o", "d"} {
+					// Generated columns keep the points in step with the
+					// lat/lon columns the loaders write.
+					statements = append(statements,
+						fmt.Sprintf("ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS %[2]s_geom geometry(Point, 4326) "+
+							"GENERATED ALWAYS AS (ST_SetSRID(ST_MakePoint(%[2]s_lon, %[2]s_lat), 4326)) STORED", table, end),
+						fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%[1]s_%[2]s_geom ON %[1]s USING GIST (%[2]s_geom)", table, end))
+				}
+			}
+			// Radius queries measure distances on the spheroid.
+			statements = append(statements,
+				"CREATE INDEX IF NOT EXISTS idx_taxis_o_geog ON taxis USING GIST (CAST(o_geom AS geography))")
+			for _, statement := range statements {
+				if err := db.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(db *gorm.DB) error {
+			if db.Dialect().GetName() != "postgres" {
+				return nil
+			}
+			// Dropping the columns drops their indexes. The extension is
+			// left in place, as other tables may use it.
+			for _, table := range []string{"passengers", "taxis"} {
+				if err := db.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS o_geom, DROP COLUMN IF EXISTS d_geom", table)).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     6,
+		Description: "create run, county_summary and state_summary tables",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&runV6{}, &countySummaryV6{}, &stateSummaryV6{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.DropTableIfExists(&stateSummaryV6{}, &countySummaryV6{}, &runV6{}).Error
+		},
+	},
+	{
+		Version:     7,
+		Description: "create id_counters table",
+		Up: func(db *gorm.DB) error {
+			if err := db.AutoMigrate(&idCounterV7{}).Error; err != nil {
+				return err
+			}
+			// Number on from the ids already loaded.
+			for _, table := range []string{"taxis", "passengers"} {
+				err := db.Exec(fmt.Sprintf("INSERT INTO id_counters (name, next_id) SELECT ?, COALESCE(MAX(id), 0) + 1 FROM %s "+
+					"WHERE NOT EXISTS (SELECT 1 FROM id_counters WHERE name = ?)", table), table, table).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(db *gorm.DB) error {
+			return db.DropTableIfExists(&idCounterV7{}).Error
+		},
+	},
+	{
+		Version:     8,
+		Description: "keep a single dataset version row",
+		Up: func(db *gorm.DB) error {
+			// The version goes on from the number of loads recorded so far.
+			var version uint64
+			if err := db.Table("dataset_versions").Select("COALESCE(MAX(id), 0)").Row().Scan(&version); err != nil {
+				return err
+			}
+			if err := db.DropTable(&datasetVersionV2{}).Error; err != nil {
+				return err
+			}
+			if err := db.CreateTable(&datasetVersionV8{}).Error; err != nil {
+				return err
+			}
+			return db.Create(&datasetVersionV8{ID: 1, Version: version}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			if err := db.DropTableIfExists(&datasetVersionV8{}).Error; err != nil {
+				return err
+			}
+			return db.CreateTable(&datasetVersionV2{}).Error
+		},
+	},
+}
+
+type passengerV1 struct {
+	ID               uint `gorm:"primary_key"`
+	CreatedAt        time.Time
+	PersonID         int64
+	OType            byte   `gorm:"index" sql:"size:1"`
+	OName            string `sql:"size:255"`
+	OFIPS            uint32 `gorm:"column:o_fips"`
+	OX               int32
+	OY               int32
+	OLat             float64
+	OLon             float64
+	DType            byte   `gorm:"index" sql:"size:1"`
+	DName            string `sql:"size:255"`
+	DFIPS            uint32 `gorm:"column:d_fips"`
+	DX               int32  `gorm:"index"`
+	DY               int32  `gorm:"index"`
+	DLat             float64
+	DLon             float64
+	DepartureTime    uint32 `gorm:"index"`
+	TripCategory     uint32 `gorm:"index"`
+	TripDistance     float64
+	LatestPickUpTime uint32 `gorm:"index"`
+	DXSuper          int32
+	DYSuper          int32
+	TaxiID           uint
+}
+
+func (passengerV1) TableName() string { return "passengers" }
+
+type taxiV1 struct {
+	ID            uint `gorm:"primary_key"`
+	CreatedAt     time.Time
+	OFIPS         uint32 `gorm:"column:o_fips"`
+	DFIPS         uint32 `gorm:"column:d_fips"`
+	OX            int32  `gorm:"index"`
+	OY            int32  `gorm:"index"`
+	OLat          float64
+	OLon          float64
+	DX            int32 `gorm:"index"`
+	DY            int32 `gorm:"index"`
+	DLat          float64
+	DLon          float64
+	DepartureTime uint32 `gorm:"index"`
+	MaxOccupancy  uint32
+	NumPassengers uint32
+	PMT           float64 `gorm:"column:pmt"`
+	VMT           float64 `gorm:"column:vmt"`
+	DXSuper       int32   `gorm:"index"`
+	DYSuper       int32   `gorm:"index"`
+}
+
+func (taxiV1) TableName() string { return "taxis" }
+
+type datasetVersionV2 struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	Source    string `sql:"size:255"`
+}
+
+func (datasetVersionV2) TableName() string { return "dataset_versions" }
+
+type passengerScenarioV3 struct {
+	Scenario string `gorm:"index;default:'default'" sql:"size:64;not null"`
+}
+
+func (passengerScenarioV3) TableName() string { return "passengers" }
+
+type taxiScenarioV3 struct {
+	Scenario string `gorm:"index;default:'default'" sql:"size:64;not null"`
+}
+
+func (taxiScenarioV3) TableName() string { return "taxis" }
+
+type passengerSourceV4 struct {
+	Source string `gorm:"index" sql:"size:255"`
+}
+
+func (passengerSourceV4) TableName() string { return "passengers" }
+
+type taxiSourceV4 struct {
+	Source string `gorm:"index" sql:"size:255"`
+}
+
+func (taxiSourceV4) TableName() string { return "taxis" }
+
+type runV6 struct {
+	ID            uint `gorm:"primary_key"`
+	CreatedAt     time.Time
+	Command       string `sql:"size:64"`
+	Scenario      string `sql:"size:64"`
+	Source        string `sql:"size:255"`
+	NumCounties   int
+	NumTaxis      int
+	NumPassengers int
+	PMT           float64 `gorm:"column:pmt"`
+	VMT           float64 `gorm:"column:vmt"`
+}
+
+func (runV6) TableName() string { return "run" }
+
+type countySummaryV6 struct {
+	ID            uint   `gorm:"primary_key"`
+	RunID         uint   `gorm:"index"`
+	FIPS          uint32 `gorm:"column:fips;index"`
+	StateFIPS     uint32 `gorm:"column:state_fips"`
+	Rows          int
+	NumTaxis      int
+	NumPassengers int
+	PMT           float64 `gorm:"column:pmt"`
+	VMT           float64 `gorm:"column:vmt"`
+}
+
+func (countySummaryV6) TableName() string { return "county_summary" }
+
+type stateSummaryV6 struct {
+	ID            uint   `gorm:"primary_key"`
+	RunID         uint   `gorm:"index"`
+	FIPS          uint32 `gorm:"column:fips;index"`
+	NumCounties   int
+	NumTaxis      int
+	NumPassengers int
+	PMT           float64 `gorm:"column:pmt"`
+	VMT           float64 `gorm:"column:vmt"`
+}
+
+func (stateSummaryV6) TableName() string { return "state_summary" }
+
+type idCounterV7 struct {
+	Name   string `gorm:"primary_key" sql:"size:64"`
+	NextID uint
+}
+
+func (idCounterV7) TableName() string { return "id_counters" }
+
+type datasetVersionV8 struct {
+	ID        uint `gorm:"primary_key"`
+	Version   uint64
+	UpdatedAt time.Time
+}
+
+func (datasetVersionV8) TableName() string { return "dataset_versions" }
+
+// dropIndexedColumn drops a column and the index AutoMigrate gave it from the
+// tables of models.
+func dropIndexedColumn(db *gorm.DB, column string, models ...interface{}) error {
+	for _, model := range models {
+		scope := db.NewScope(model)
+		index := fmt.Sprintf("idx_%s_%s", scope.TableName(), column)
+		if scope.Dialect().HasIndex(scope.TableName(), index) {
+			if err := db.Model(model).RemoveIndex(index).Error; err != nil {
+				return err
+			}
+		}
+		if scope.Dialect().HasColumn(scope.TableName(), column) {
+			if err := db.Model(model).DropColumn(column).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LatestSchemaVersion is the version of the last migration, the schema this
+// build expects.
+func LatestSchemaVersion() uint {
+	if len(Migrations) == 0 {
+		return 0
+	}
+	return Migrations[len(Migrations)-1].Version
+}
+
+// AppliedSchemaVersion returns the version of the last applied migration, or 0
+// if none has been.
+func AppliedSchemaVersion(db *gorm.DB) (uint, error) {
+	if !db.HasTable(&SchemaMigration{}) {
+		return 0, nil
+	}
+	var version uint
+	if err := db.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Row().Scan(&version); err != nil {
+		return 0, fmt.Errorf("could not read schema version: %v", err)
+	}
+	return version, nil
+}
+
+// CheckSchemaVersion returns an error unless version is the schema version
+// this build expects.
+func CheckSchemaVersion(version uint) error {
+	latest := LatestSchemaVersion()
+	if version < latest {
+		return fmt.Errorf("database schema is at version %d, but version %d is required: run migrate up", version, latest)
+	}
+	if version > latest {
+		return fmt.Errorf("database schema is at version %d, newer than version %d this build supports: upgrade the app or run migrate down %d",
+			version, latest, latest)
+	}
+	return nil
+}
+
+// appliedMigrations returns the applied migrations by version.
+func appliedMigrations(db *gorm.DB) (map[uint]SchemaMigration, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}).Error; err != nil {
+		return nil, fmt.Errorf("could not create schema_migrations table: %v", err)
+	}
+	var records []SchemaMigration
+	if err := db.Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("could not read applied migrations: %v", err)
+	}
+	applied := make(map[uint]SchemaMigration)
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// MigrationStatuses returns every migration and whether it has been applied.
+func MigrationStatuses(db *gorm.DB) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []MigrationStatus
+	for _, migration := range Migrations {
+		record, ok := applied[migration.Version]
+		statuses = append(statuses, MigrationStatus{Migration: migration, Applied: ok, AppliedAt: record.AppliedAt})
+	}
+	return statuses, nil
+}
+
+// inTransaction runs a migration step and its schema_migrations write in one
+// transaction, so that a failed step is rolled back and not recorded. MySQL
+// commits schema changes as they are made, so there only the record is rolled
+// back.
+func inTransaction(db *gorm.DB, step func(tx *gorm.DB) error) error {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := step(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// MigrateUp applies the pending migrations up to and including version target,
+// in order, and returns those it applied. It stops at the first that fails.
+func MigrateUp(db *gorm.DB, target uint) ([]Migration, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, migration := range Migrations {
+		if migration.Version > target {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		err := inTransaction(db, func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return fmt.Errorf("migration %d (%s) failed: %v", migration.Version, migration.Description, err)
+			}
+			record := SchemaMigration{Version: migration.Version, Description: migration.Description, AppliedAt: time.Now()}
+			if err := tx.Create(&record).Error; err != nil {
+				return fmt.Errorf("could not record migration %d: %v", migration.Version, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the applied migrations newer than version target, newest
+// first, and returns those it reverted. It stops at the first that fails.
+func MigrateDown(db *gorm.DB, target uint) ([]Migration, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	migrations := append([]Migration(nil), Migrations...)
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version > migrations[j].Version })
+	var done []Migration
+	for _, migration := range migrations {
+		if migration.Version <= target {
+			break
+		}
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		err := inTransaction(db, func(tx *gorm.DB) error {
+			if err := migration.Down(tx); err != nil {
+				return fmt.Errorf("reverting migration %d (%s) failed: %v", migration.Version, migration.Description, err)
+			}
+			if err := tx.Delete(&SchemaMigration{}, "version = ?", migration.Version).Error; err != nil {
+				return fmt.Errorf("could not record reverting migration %d: %v", migration.Version, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
@@ -2,6 +2,7 @@ package ataxi
 
 import (
 	"math"
+	"sort"
 	"strconv"
 	"time"
 
@@ -86,14 +87,14 @@ type Passenger struct {
 	ID               uint `gorm:"primary_key"`
 	CreatedAt        time.Time
 	PersonID         int64
-	OType            byte   `gorm:"index";sql:"size:1"`
+	OType            byte   `gorm:"index" sql:"size:1"`
 	OName            string `sql:"size:255"`
 	OFIPS            uint32 `gorm:"column:o_fips"`
 	OX               int32
 	OY               int32
 	OLat             float64
 	OLon             float64
-	DType            byte   `gorm:"index";sql:"size:1"`
+	DType            byte   `gorm:"index" sql:"size:1"`
 	DName            string `sql:"size:255"`
 	DFIPS            uint32 `gorm:"column:d_fips"`
 	DX               int32  `gorm:"index"`
@@ -107,6 +108,8 @@ type Passenger struct {
 	DXSuper          int32
 	DYSuper          int32
 	TaxiID           uint
+	Scenario         string `gorm:"index;default:'default'" sql:"size:64;not null"`
+	Source           string `gorm:"index" sql:"size:255"`
 }
 
 func NewPassenger(id uint, personID int64, oType byte, oName string, oFIPS uint32,
@@ -193,6 +196,8 @@ type Taxi struct {
 	VMT           float64 `gorm:"column:vmt"`
 	DXSuper       int32   `gorm:"index"`
 	DYSuper       int32   `gorm:"index"`
+	Scenario      string  `gorm:"index;default:'default'" sql:"size:64;not null"`
+	Source        string  `gorm:"index" sql:"size:255"`
 }
 
 func NewTaxi(id uint, passenger *Passenger, maxOccupancy uint32) *Taxi {
@@ -222,6 +227,12 @@ func (taxi *Taxi) HasDeparted(time uint32) bool {
 	return taxi.DepartureTime <= time
 }
 
+// MadeEmptyTime returns the time the taxi drops off its last passenger,
+// assuming it travels its VMT at TaxiSpeed.
+func (taxi *Taxi) MadeEmptyTime() SimTime {
+	return SimTime(taxi.DepartureTime).Add(TravelTime(taxi.VMT))
+}
+
 func (taxi *Taxi) AddPassenger(passenger *Passenger) {
 	taxi.Passengers = append(taxi.Passengers, *passenger)
 	taxi.NumPassengers++
@@ -276,14 +287,131 @@ func (taxi *Taxi) PersonMilesTraveled() float64 {
 	return ptm
 }
 
+// DatasetVersion is the single row stamping the stored taxis, passengers and
+// run summaries. The loaders bump it with BumpDatasetVersion whenever they
+// change them.
+type DatasetVersion struct {
+	ID        uint `gorm:"primary_key"`
+	Version   uint64
+	UpdatedAt time.Time
+}
+
+// LatLonBounds is the bounding box of the trip ends counted in a superpixel.
+type LatLonBounds struct {
+	MinLat float64 `gorm:"column:min_lat"`
+	MaxLat float64 `gorm:"column:max_lat"`
+	MinLon float64 `gorm:"column:min_lon"`
+	MaxLon float64 `gorm:"column:max_lon"`
+}
+
+// union returns the bounding box of both bounds.
+func (b LatLonBounds) union(o LatLonBounds) LatLonBounds {
+	return LatLonBounds{
+		MinLat: math.Min(b.MinLat, o.MinLat),
+		MaxLat: math.Max(b.MaxLat, o.MaxLat),
+		MinLon: math.Min(b.MinLon, o.MinLon),
+		MaxLon: math.Max(b.MaxLon, o.MaxLon),
+	}
+}
+
 type SuperPixelDemand struct {
-	Count int   `gorm:"column:c"`
-	X     int32 `gorm:"column:ox"`
-	Y     int32 `gorm:"column:oy"`
+	Count int    `gorm:"column:c"`
+	X     int32  `gorm:"column:x"`
+	Y     int32  `gorm:"column:y"`
+	Time  uint32 `gorm:"column:bucket"`
+	LatLonBounds
 }
 
 type SuperPixelSupply struct {
-	Count int   `gorm:"column:c"`
-	X     int32 `gorm:"column:dx_super"`
-	Y     int32 `gorm:"column:dy_super"`
+	Count int    `gorm:"column:c"`
+	X     int32  `gorm:"column:x"`
+	Y     int32  `gorm:"column:y"`
+	Time  uint32 `gorm:"column:bucket"`
+	LatLonBounds
+}
+
+// SuperPixelNet is the number of taxis arriving at (supply) and departing
+// from (demand) a superpixel during a time bucket, and the resulting surplus.
+// Its bounds cover the taxis' origins and destinations in the superpixel.
+type SuperPixelNet struct {
+	X      int32
+	Y      int32
+	Time   uint32
+	Supply int
+	Demand int
+	Net    int
+	LatLonBounds
+}
+
+// NetSupplyDemand sums supply and demand per superpixel and time bucket.
+func NetSupplyDemand(demand []SuperPixelDemand, supply []SuperPixelSupply) []SuperPixelNet {
+	type cell struct {
+		x, y int32
+		t    uint32
+	}
+	index := make(map[cell]int)
+	var results []SuperPixelNet
+	get := func(x int32, y int32, t uint32, bounds LatLonBounds) *SuperPixelNet {
+		c := cell{x, y, t}
+		i, ok := index[c]
+		if !ok {
+			i = len(results)
+			index[c] = i
+			results = append(results, SuperPixelNet{X: x, Y: y, Time: t, LatLonBounds: bounds})
+		}
+		net := &results[i]
+		net.LatLonBounds = net.LatLonBounds.union(bounds)
+		return net
+	}
+	for _, d := range demand {
+		net := get(d.X, d.Y, d.Time, d.LatLonBounds)
+		net.Demand += d.Count
+		net.Net -= d.Count
+	}
+	for _, s := range supply {
+		net := get(s.X, s.Y, s.Time, s.LatLonBounds)
+		net.Supply += s.Count
+		net.Net += s.Count
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.X != b.X {
+			return a.X < b.X
+		}
+		if a.Y != b.Y {
+			return a.Y < b.Y
+		}
+		return a.Time < b.Time
+	})
+	return results
+}
+
+// AVOStats are the passenger and vehicle miles traveled by a group of taxis.
+type AVOStats struct {
+	Key           string  `gorm:"column:group_key"`
+	Name          string  `gorm:"-"`
+	NumTaxis      int     `gorm:"column:num_taxis"`
+	NumPassengers int     `gorm:"column:num_passengers"`
+	PMT           float64 `gorm:"column:pmt"`
+	VMT           float64 `gorm:"column:vmt"`
+	AVO           float64 `gorm:"-"`
+}
+
+// fill computes the AVO of stats and names its county or state group.
+func (stats *AVOStats) fill(groupBy string) {
+	if stats.VMT > 0 {
+		stats.AVO = stats.PMT / stats.VMT
+	}
+	fips, err := strconv.ParseUint(stats.Key, 10, 32)
+	if err != nil {
+		return
+	}
+	switch groupBy {
+	case "county":
+		county, _ := GetCounty(uint32(fips))
+		stats.Name = county.Name
+	case "state":
+		state, _ := GetState(uint32(fips))
+		stats.Name = state.Name
+	}
 }
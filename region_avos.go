@@ -0,0 +1,97 @@
+package ataxi
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// The AVO results written by region_avo, relative to the command directories.
+const (
+	DefaultCountyAVOsFile = "../data/county_avos.csv"
+	DefaultStateAVOsFile  = "../data/state_avos.csv"
+)
+
+// RegionAVO is the AVO, PMT and VMT of a county or state, as written by
+// region_avo.
+type RegionAVO struct {
+	FIPS      uint32
+	Name      string
+	StateAbbr string
+	StateName string
+	AVO       float64
+	PMT       float64
+	VMT       float64
+}
+
+// ReadCountyAVOs reads the per county results of region_avo.
+func ReadCountyAVOs(path string) ([]RegionAVO, error) {
+	return readRegionAVOs(path, "County", "CountyName")
+}
+
+// ReadStateAVOs reads the per state results of region_avo.
+func ReadStateAVOs(path string) ([]RegionAVO, error) {
+	return readRegionAVOs(path, "State", "StateName")
+}
+
+// readRegionAVOs reads a csv of AVO results, finding its columns by the
+// names in its header.
+func readRegionAVOs(path string, fipsColumn string, nameColumn string) ([]RegionAVO, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read header of %s: %v", path, err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range []string{fipsColumn, nameColumn, "StateAbbr", "StateName", "AVO", "PMT", "VMT"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%s has no %s column", path, name)
+		}
+	}
+
+	var results []RegionAVO
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("could not read %s: %v", path, err)
+		}
+		fips, err := strconv.ParseUint(line[columns[fipsColumn]], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FIPS code in %s: %q", path, line[columns[fipsColumn]])
+		}
+		result := RegionAVO{
+			FIPS:      uint32(fips),
+			Name:      line[columns[nameColumn]],
+			StateAbbr: line[columns["StateAbbr"]],
+			StateName: line[columns["StateName"]],
+		}
+		values := map[string]*float64{"AVO": &result.AVO, "PMT": &result.PMT, "VMT": &result.VMT}
+		for name, dst := range values {
+			v, err := strconv.ParseFloat(line[columns[name]], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s in %s: %q", name, path, line[columns[name]])
+			}
+			// Regions without vehicle miles have an undefined AVO.
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				v = 0
+			}
+			*dst = v
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
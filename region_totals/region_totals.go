@@ -7,9 +7,9 @@ import (
 	"fmt"
 	"io"
 	"log"
-    "math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"time"
 
@@ -17,6 +17,50 @@ import (
 	"github.com/webapps/ataxi"
 )
 
+const numCategories = 5
+
+var categoryColumns = []string{"WalkTrips", "ShortTrips", "NormalTrips", "LongTrips", "ReallyLongTrips"}
+
+type categoryTotals [numCategories]int
+
+func (totals *categoryTotals) row() []string {
+	var row []string
+	for c := 0; c < numCategories; c++ {
+		row = append(row, strconv.Itoa(totals[c]))
+	}
+	return row
+}
+
+// totalsFor returns the totals of a state or county, creating them if needed.
+func totalsFor(m map[uint32]*categoryTotals, fips uint32) *categoryTotals {
+	totals, ok := m[fips]
+	if !ok {
+		totals = &categoryTotals{}
+		m[fips] = totals
+	}
+	return totals
+}
+
+// odTotalsFor returns the totals of an origin and destination county pair,
+// creating them if needed.
+func odTotalsFor(m map[[2]uint32]*categoryTotals, od [2]uint32) *categoryTotals {
+	totals, ok := m[od]
+	if !ok {
+		totals = &categoryTotals{}
+		m[od] = totals
+	}
+	return totals
+}
+
+func sortedKeys(m map[uint32]*categoryTotals) []uint32 {
+	var keys []uint32
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
 func main() {
 	if len(os.Args) != 2 {
 		log.Fatal(errors.New("You must provide a data directory containing the ataxi mode trip files."))
@@ -28,14 +72,26 @@ func main() {
 		log.Fatal(err)
 	}
 
-	regionTotals := make(map[int]int)
-    const mileBuckets = 401
-    var tripLengthCumulative [mileBuckets]int
-    start := time.Now()
+	var regionTotals categoryTotals
+	stateTotals := make(map[uint32]*categoryTotals)
+	countyTotals := make(map[uint32]*categoryTotals)
+	odFlows := make(map[[2]uint32]*categoryTotals)
+	// One mile trip length buckets, the last one holding trips of 400 miles
+	// or more.
+	mileBins, err := ataxi.LinearBins(0, 400, 400)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tripLengths := ataxi.NewHistogram(mileBins)
+	start := time.Now()
 	for _, file := range files {
 		_, filename := filepath.Split(file)
 		fmt.Printf("Processing %s\n", filename)
-		csvFile, _ := os.Open(file)
+		fileFIPS, _ := ataxi.FIPSFromFilename(filename)
+		csvFile, err := os.Open(file)
+		if err != nil {
+			log.Fatal(err)
+		}
 		reader := csv.NewReader(bufio.NewReader(csvFile))
 		if _, err := reader.Read(); err != nil {
 			log.Fatal(err)
@@ -50,17 +106,25 @@ func main() {
 			row := ataxi.ParseLine(line)
 			tripDistance := ataxi.GetTripDistance(geo.NewPoint(row.OLat, row.OLon),
 				geo.NewPoint(row.DLat, row.DLon))
-            lengthIdx := int(math.Floor(tripDistance))
-            if lengthIdx >= mileBuckets-1 {
-                lengthIdx = mileBuckets-1
-            }
-            tripLengthCumulative[lengthIdx]++
 			tripCategory := ataxi.GetTripCategory(tripDistance)
-			regionTotals[int(tripCategory)]++
+			tripLengths.Add(tripDistance, 1)
+
+			countyFIPS := row.OFIPS
+			if countyFIPS == 0 {
+				countyFIPS = fileFIPS
+			}
+			regionTotals[tripCategory]++
+			totalsFor(stateTotals, ataxi.StateFIPS(countyFIPS))[tripCategory]++
+			totalsFor(countyTotals, countyFIPS)[tripCategory]++
+			if row.DFIPS != 0 && row.DFIPS != countyFIPS {
+				odTotalsFor(odFlows, [2]uint32{countyFIPS, row.DFIPS})[tripCategory]++
+			}
 		}
+		csvFile.Close()
 	}
 	elapsed := time.Since(start)
 	fmt.Printf("csv processing took %s\n", elapsed)
+
 	fmt.Println("Creating region_totals.csv")
 	regionFile, err := os.Create("../data/region_totals.csv")
 	if err != nil {
@@ -71,38 +135,65 @@ func main() {
 	regionWriter := csv.NewWriter(regionFile)
 	defer regionWriter.Flush()
 
-	columns := []string{"WalkTrips", "ShortTrips", "NormalTrips", "LongTrips", "ReallyLongTrips"}
-	regionWriter.Write(columns)
+	regionWriter.Write(append([]string{"Level", "FIPS", "Name", "StateAbbr"}, categoryColumns...))
+	regionWriter.Write(append([]string{"region", "", "", ""}, regionTotals.row()...))
+	for _, fips := range sortedKeys(stateTotals) {
+		state, _ := ataxi.GetState(fips)
+		regionWriter.Write(append([]string{"state", ataxi.FormatStateFIPS(fips), state.Name, state.Abbreviation},
+			stateTotals[fips].row()...))
+	}
+	for _, fips := range sortedKeys(countyTotals) {
+		county, _ := ataxi.GetCounty(fips)
+		regionWriter.Write(append([]string{"county", ataxi.FormatCountyFIPS(fips), county.Name, county.State.Abbreviation},
+			countyTotals[fips].row()...))
+	}
+	fmt.Println("Finished")
 
-	var row []string
-	for c := 0; c < 5; c++ {
-		row = append(row, strconv.Itoa(regionTotals[c]))
+	fmt.Println("Creating od_flows.csv")
+	flowFile, err := os.Create("../data/od_flows.csv")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer flowFile.Close()
+
+	flowWriter := csv.NewWriter(flowFile)
+	defer flowWriter.Flush()
+
+	var ods [][2]uint32
+	for od := range odFlows {
+		ods = append(ods, od)
+	}
+	sort.Slice(ods, func(i, j int) bool {
+		if ods[i][0] != ods[j][0] {
+			return ods[i][0] < ods[j][0]
+		}
+		return ods[i][1] < ods[j][1]
+	})
+	flowWriter.Write(append([]string{"OFIPS", "OCountyName", "OStateAbbr", "DFIPS", "DCountyName", "DStateAbbr"},
+		categoryColumns...))
+	for _, od := range ods {
+		origin, _ := ataxi.GetCounty(od[0])
+		destination, _ := ataxi.GetCounty(od[1])
+		flowWriter.Write(append([]string{
+			ataxi.FormatCountyFIPS(od[0]), origin.Name, origin.State.Abbreviation,
+			ataxi.FormatCountyFIPS(od[1]), destination.Name, destination.State.Abbreviation,
+		}, odFlows[od].row()...))
 	}
-	regionWriter.Write(row)
 	fmt.Println("Finished")
 
-    for i := 0; i < len(tripLengthCumulative); i++ {
-        if i != 0 {
-            tripLengthCumulative[i] += tripLengthCumulative[i-1]
-        }
-    }
-    fmt.Println("Creating trip_length_cumulative.csv")
-    tripLengthFile, err := os.Create("../data/trip_length_cumulative.csv")
-    if err != nil {
-        log.Fatal(err)
-    }
-    defer tripLengthFile.Close()
-
-    tripLengthWriter := csv.NewWriter(tripLengthFile)
-    defer tripLengthWriter.Flush()
-
-    columns = []string{"mile", "cumulative"}
-    tripLengthWriter.Write(columns)
-
-    for mile, count := range tripLengthCumulative {
-        row[0] = strconv.Itoa(mile)
-        row[1] = strconv.Itoa(count)
-        tripLengthWriter.Write(row[:2])
-    }
-    fmt.Println("Finished")
+	fmt.Println("Creating trip_length_cumulative.csv")
+	tripLengthFile, err := os.Create("../data/trip_length_cumulative.csv")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tripLengthFile.Close()
+
+	tripLengthWriter := csv.NewWriter(tripLengthFile)
+	defer tripLengthWriter.Flush()
+
+	tripLengthWriter.Write([]string{"mile", "cumulative"})
+	for mile, count := range tripLengths.Cumulative() {
+		tripLengthWriter.Write([]string{strconv.Itoa(mile), strconv.Itoa(int(count))})
+	}
+	fmt.Println("Finished")
 }
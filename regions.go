@@ -0,0 +1,138 @@
+package ataxi
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Unassigned is the region of counties that are not covered by a grouping.
+const Unassigned = "Unassigned"
+
+// censusDivisions lists the state FIPS codes in each Census division.
+var censusDivisions = map[string][]uint32{
+	"New England":        {9, 23, 25, 33, 44, 50},
+	"Middle Atlantic":    {34, 36, 42},
+	"East North Central": {17, 18, 26, 39, 55},
+	"West North Central": {19, 20, 27, 29, 31, 38, 46},
+	"South Atlantic":     {10, 11, 12, 13, 24, 37, 45, 51, 54},
+	"East South Central": {1, 21, 28, 47},
+	"West South Central": {5, 22, 40, 48},
+	"Mountain":           {4, 8, 16, 30, 32, 35, 49, 56},
+	"Pacific":            {2, 6, 15, 41, 53},
+}
+
+// censusRegions maps Census divisions to their Census region.
+var censusRegions = map[string]string{
+	"New England":        "Northeast",
+	"Middle Atlantic":    "Northeast",
+	"East North Central": "Midwest",
+	"West North Central": "Midwest",
+	"South Atlantic":     "South",
+	"East South Central": "South",
+	"West South Central": "South",
+	"Mountain":           "West",
+	"Pacific":            "West",
+}
+
+// RegionGrouping maps counties to named regions, either by their state or
+// individually. County assignments take precedence over state assignments.
+type RegionGrouping struct {
+	states   map[uint32]string
+	counties map[uint32]string
+}
+
+// NewRegionGrouping returns an empty grouping.
+func NewRegionGrouping() *RegionGrouping {
+	return &RegionGrouping{
+		states:   make(map[uint32]string),
+		counties: make(map[uint32]string),
+	}
+}
+
+// CensusDivisions returns the grouping of states into the nine Census divisions.
+func CensusDivisions() *RegionGrouping {
+	grouping := NewRegionGrouping()
+	for division, members := range censusDivisions {
+		for _, state := range members {
+			grouping.states[state] = division
+		}
+	}
+	return grouping
+}
+
+// CensusRegions returns the grouping of states into the four Census regions.
+func CensusRegions() *RegionGrouping {
+	grouping := NewRegionGrouping()
+	for division, members := range censusDivisions {
+		for _, state := range members {
+			grouping.states[state] = censusRegions[division]
+		}
+	}
+	return grouping
+}
+
+// LoadRegionGrouping reads a custom grouping from a csv file with a header
+// row and FIPS, Region columns. Two digit FIPS codes assign a whole state,
+// five digit codes a single county.
+func LoadRegionGrouping(path string) (*RegionGrouping, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(bufio.NewReader(file))
+	reader.FieldsPerRecord = 2
+	if _, err := reader.Read(); err != nil {
+		return nil, fmt.Errorf("could not read region grouping %s: %v", path, err)
+	}
+	grouping := NewRegionGrouping()
+	for row := 2; ; row++ {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("could not read region grouping %s: %v", path, err)
+		}
+		code := strings.TrimSpace(line[0])
+		fips, err := strconv.ParseUint(code, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FIPS code %q on line %d of region grouping %s", code, row, path)
+		}
+		region := strings.TrimSpace(line[1])
+		if len(code) <= 2 {
+			grouping.states[uint32(fips)] = region
+		} else {
+			grouping.counties[uint32(fips)] = region
+		}
+	}
+	return grouping, nil
+}
+
+// GetRegionGrouping returns the built-in grouping with the given name
+// ("census-region" or "census-division"), or loads a custom grouping file.
+func GetRegionGrouping(name string) (*RegionGrouping, error) {
+	switch name {
+	case "census-region":
+		return CensusRegions(), nil
+	case "census-division":
+		return CensusDivisions(), nil
+	}
+	return LoadRegionGrouping(name)
+}
+
+// Region returns the name of the region a county belongs to, or Unassigned.
+func (grouping *RegionGrouping) Region(countyFIPS uint32) string {
+	if region, ok := grouping.counties[countyFIPS]; ok {
+		return region
+	}
+	if region, ok := grouping.states[StateFIPS(countyFIPS)]; ok {
+		return region
+	}
+	return Unassigned
+}
@@ -0,0 +1,43 @@
+package ataxi
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadRegionGrouping(t *testing.T) {
+	tests := []struct {
+		contents string
+		want     string
+	}{
+		{"FIPS,Region\n06,West\n36061,Manhattan\n", ""},
+		{"FIPS,Region\n06,West\n36061\n", "line 3"},
+		{"FIPS,Region\n06,West,Pacific\n", "line 2"},
+		{"FIPS,Region\nCA,West\n", "line 2"},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "regions.csv")
+		if err := os.WriteFile(path, []byte(test.contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		grouping, err := LoadRegionGrouping(path)
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("%q: %v", test.contents, err)
+				continue
+			}
+			if region := grouping.Region(6037); region != "West" {
+				t.Errorf("%q: got region %s for 06037, want West", test.contents, region)
+			}
+			if region := grouping.Region(36061); region != "Manhattan" {
+				t.Errorf("%q: got region %s for 36061, want Manhattan", test.contents, region)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), path) || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%q: got error %v, want one naming %s and %s", test.contents, err, path, test.want)
+		}
+	}
+}
@@ -1,51 +1,164 @@
 package ataxi
 
-/*
 import (
-	"log"
-)*/
+	"context"
+	"errors"
+)
 
+// DB is the database used by the app, opened with OpenDB.
 var DB RideSharingDatabase
 
-/*
-func init() {
-	var err error
-	DB, err = newMySQLDB(Config)
-	if err != nil {
-		log.Fatal(err)
-	}
-}*/
+// ErrNotFound is returned when a requested taxi, passenger or run does not
+// exist.
+var ErrNotFound = errors.New("not found")
 
+// ErrInvalidArgument is returned for queries the database cannot run, such as
+// an unknown grouping or ordering.
+var ErrInvalidArgument = errors.New("invalid argument")
+
+// TaxiOrderings are the fields taxis can be ordered by.
+var TaxiOrderings = []string{"departure_time", "num_passengers"}
+
+// TaxiFilter restricts the taxis returned by a query. Nil fields match every
+// taxi.
+type TaxiFilter struct {
+	ID             *uint
+	OX             *int32
+	OY             *int32
+	DXSuper        *int32
+	DYSuper        *int32
+	OFIPS          *uint32
+	DFIPS          *uint32
+	DepartureStart *uint32 // inclusive
+	DepartureEnd   *uint32 // exclusive
+	NumPassengers  *uint32
+	TripCategory   *uint32 // matches taxis carrying a passenger of this category
+	Scenario       *string
+}
+
+// MaxSuperPixelSize is the largest superpixel supply and demand can be
+// aggregated to.
+const MaxSuperPixelSize = 100
+
+// SupplyDemandQuery selects the resolution and time window of a supply and
+// demand query.
+type SupplyDemandQuery struct {
+	Size     int32   // superpixel size in pixels, between 1 and MaxSuperPixelSize
+	Start    *uint32 // inclusive
+	End      *uint32 // exclusive
+	Bucket   uint32  // seconds per time bucket, or 0 for a single bucket
+	Scenario *string // only count the taxis of this scenario
+}
+
+// AVOGroupings are the groupings AVO statistics can be computed for.
+var AVOGroupings = []string{"all", "county", "state", "time_category", "hour", "trip_category"}
+
+// SupplyDemandGroupings are the groupings supply and demand totals can be
+// computed for.
+var SupplyDemandGroupings = []string{"county", "hour"}
+
+// RideSharingDatabase stores the taxis and passengers of simulation runs and
+// answers the queries of the app. Queries are canceled when their context is
+// done, and their errors wrap ErrNotFound and ErrInvalidArgument where these
+// apply.
 type RideSharingDatabase interface {
 	// ListTaxis returns a list of taxis, ordered by field.
-	ListTaxis(orderBy string, limit int, withPassengers bool) ([]Taxi, error)
+	ListTaxis(ctx context.Context, orderBy string, limit int, withPassengers bool) ([]Taxi, error)
 
 	// ListTaxisByDepartureTime returns a list of taxis, ordered by departure time.
-	ListTaxisByDepartureTime(limit int, withPassengers bool) ([]Taxi, error)
+	ListTaxisByDepartureTime(ctx context.Context, limit int, withPassengers bool) ([]Taxi, error)
 
 	// ListTaxisByNumPassengers returns a list of taxis, ordered by number of passengers.
-	ListTaxisByNumPassengers(limit int, withPassengers bool) ([]Taxi, error)
+	ListTaxisByNumPassengers(ctx context.Context, limit int, withPassengers bool) ([]Taxi, error)
+
+	// QueryTaxis returns the taxis matching filter, ordered by field and
+	// skipping the first offset taxis.
+	QueryTaxis(ctx context.Context, filter TaxiFilter, orderBy string, offset int, limit int, withPassengers bool) ([]Taxi, error)
+
+	// StreamTaxis calls fn with each taxi matching filter, ordered by field and
+	// skipping the first offset taxis, without loading them all in memory. A
+	// limit of 0 streams every matching taxi.
+	StreamTaxis(ctx context.Context, filter TaxiFilter, orderBy string, offset int, limit int, withPassengers bool, fn func(*Taxi) error) error
+
+	// CountTaxis returns the number of taxis matching filter.
+	CountTaxis(ctx context.Context, filter TaxiFilter) (int, error)
 
 	// GetTaxi retrieves a taxi by its ID.
-	GetTaxi(id uint) (*Taxi, error)
+	GetTaxi(ctx context.Context, id uint) (*Taxi, error)
 
 	// ListPassengers returns a list of passengers, ordered by departure time.
-	ListPassengers(limit int) ([]Passenger, error)
+	ListPassengers(ctx context.Context, limit int) ([]Passenger, error)
+
+	// StreamPassengers calls fn with each passenger, ordered by departure time,
+	// without loading them all in memory. A limit of 0 streams every passenger.
+	StreamPassengers(ctx context.Context, limit int, fn func(*Passenger) error) error
+
+	// ListPassengersForTaxi returns the passengers of a taxi, ordered by departure time.
+	ListPassengersForTaxi(ctx context.Context, taxiID uint) ([]Passenger, error)
 
 	// GetPassenger retrieves a passenger by its ID.
-	GetPassenger(id uint) (*Passenger, error)
+	GetPassenger(ctx context.Context, id uint) (*Passenger, error)
 
-	// GetDemandForPixels returns the supply of taxis for each pixel
-	GetDemandForPixels(size int) ([]SuperPixelDemand, error)
+	// GetDemandForPixels returns the number of taxis departing from each
+	// superpixel, by departure time.
+	GetDemandForPixels(ctx context.Context, query SupplyDemandQuery) ([]SuperPixelDemand, error)
 
-	// GetSupplyForPixels returns the supply of taxis for each pixel
-	GetSupplyForPixels(size int) ([]SuperPixelSupply, error)
+	// GetSupplyForPixels returns the number of taxis made empty in each
+	// superpixel, by the time they drop off their last passenger.
+	GetSupplyForPixels(ctx context.Context, query SupplyDemandQuery) ([]SuperPixelSupply, error)
 
 	// GetNumTripsForCategory returns the number of trips for a given trip category
-	GetNumTripsForCategory(category int) (int, error)
+	GetNumTripsForCategory(ctx context.Context, category int) (int, error)
 
 	// GetCumulativeNumTripsForCategory returns the cumulative number of trips for trip categories <= category
-	GetCumulativeNumTripsForCategory(category int) (int, error)
+	GetCumulativeNumTripsForCategory(ctx context.Context, category int) (int, error)
+
+	// GetAVOStats returns the AVO, PMT and VMT of the taxis matching filter,
+	// grouped by one of AVOGroupings.
+	GetAVOStats(ctx context.Context, groupBy string, filter TaxiFilter) ([]AVOStats, error)
+
+	// GetSupplyDemandByGroup returns the supply (taxis made empty) and demand
+	// (taxis departing) of the taxis matching filter, grouped by one of
+	// SupplyDemandGroupings: by the county or hour a taxi departs for demand,
+	// and the county or hour it is made empty for supply.
+	GetSupplyDemandByGroup(ctx context.Context, groupBy string, filter TaxiFilter) ([]GroupSupplyDemand, error)
+
+	// ListScenarios returns the scenarios of the stored taxis, by name.
+	ListScenarios(ctx context.Context) ([]ScenarioSummary, error)
+
+	// ListTaxisWithinRadius returns the taxis matching filter departing within
+	// radius meters of a point, ordered by departure time.
+	ListTaxisWithinRadius(ctx context.Context, lat float64, lon float64, radius float64, filter TaxiFilter, limit int) ([]Taxi, error)
+
+	// ListTaxisWithinPolygon returns the taxis matching filter departing
+	// within a GeoJSON polygon, ordered by departure time.
+	ListTaxisWithinPolygon(ctx context.Context, polygon string, filter TaxiFilter, limit int) ([]Taxi, error)
+
+	// GetDemandForPolygons returns the number of taxis matching filter
+	// departing from each polygon, in the order of polygons.
+	GetDemandForPolygons(ctx context.Context, polygons []Polygon, filter TaxiFilter) ([]PolygonDemand, error)
+
+	// ListRuns returns the stored runs of region_avo and db_populate, newest
+	// first.
+	ListRuns(ctx context.Context) ([]Run, error)
+
+	// GetCountySummaries returns the county summaries of a run, by FIPS code.
+	// A runID of 0 is the latest run.
+	GetCountySummaries(ctx context.Context, runID uint) ([]CountySummary, error)
+
+	// GetStateSummaries returns the state summaries of a run, by FIPS code.
+	// A runID of 0 is the latest run.
+	GetStateSummaries(ctx context.Context, runID uint) ([]StateSummary, error)
+
+	// DatasetVersion returns a stamp that changes whenever the taxis and
+	// passengers are reloaded.
+	DatasetVersion(ctx context.Context) (string, error)
+
+	// SchemaVersion returns the version of the last applied schema migration.
+	SchemaVersion(ctx context.Context) (uint, error)
+
+	// Ping checks that the database is reachable.
+	Ping(ctx context.Context) error
 
 	// Close closes the database, freeing up any available resources.
 	Close()
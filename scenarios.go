@@ -0,0 +1,140 @@
+package ataxi
+
+import "sort"
+
+// DefaultScenario is the scenario of taxis and passengers loaded without one,
+// including those loaded before scenarios were recorded.
+const DefaultScenario = "default"
+
+// ScenarioSummary is the size of a simulation run stored in the database.
+type ScenarioSummary struct {
+	Name          string `gorm:"column:name"`
+	NumTaxis      int    `gorm:"column:num_taxis"`
+	NumPassengers int    `gorm:"column:num_passengers"`
+}
+
+// GroupSupplyDemand is the number of taxis made empty in (supply) and
+// departing from (demand) a county or hour.
+type GroupSupplyDemand struct {
+	Key    string `gorm:"column:group_key"`
+	Supply int    `gorm:"column:supply"`
+	Demand int    `gorm:"column:demand"`
+	Net    int    `gorm:"-"`
+}
+
+// ScenarioStats are the fleet size, miles traveled and supply and demand of
+// the taxis of a scenario in a county or hour.
+type ScenarioStats struct {
+	NumTaxis      int
+	NumPassengers int
+	PMT           float64
+	VMT           float64
+	AVO           float64
+	Supply        int
+	Demand        int
+	Net           int
+}
+
+// ScenarioComparison compares two scenarios in a county or hour. Diff is B - A.
+type ScenarioComparison struct {
+	Key  string
+	Name string
+	A    ScenarioStats
+	B    ScenarioStats
+	Diff ScenarioStats
+}
+
+// CompareScenarios lines up the AVO statistics and supply and demand of two
+// scenarios, grouped the same way, by group key. Groups missing from one
+// scenario count as empty in it.
+func CompareScenarios(avoA []AVOStats, sdA []GroupSupplyDemand, avoB []AVOStats, sdB []GroupSupplyDemand) []ScenarioComparison {
+	index := make(map[string]int)
+	var results []ScenarioComparison
+	get := func(key string) *ScenarioComparison {
+		i, ok := index[key]
+		if !ok {
+			i = len(results)
+			index[key] = i
+			results = append(results, ScenarioComparison{Key: key})
+		}
+		return &results[i]
+	}
+	addAVO := func(stats []AVOStats, side func(*ScenarioComparison) *ScenarioStats) {
+		for _, s := range stats {
+			c := get(s.Key)
+			if s.Name != "" {
+				c.Name = s.Name
+			}
+			dst := side(c)
+			dst.NumTaxis = s.NumTaxis
+			dst.NumPassengers = s.NumPassengers
+			dst.PMT = s.PMT
+			dst.VMT = s.VMT
+			dst.AVO = s.AVO
+		}
+	}
+	addSupplyDemand := func(groups []GroupSupplyDemand, side func(*ScenarioComparison) *ScenarioStats) {
+		for _, g := range groups {
+			dst := side(get(g.Key))
+			dst.Supply = g.Supply
+			dst.Demand = g.Demand
+			dst.Net = g.Net
+		}
+	}
+	a := func(c *ScenarioComparison) *ScenarioStats { return &c.A }
+	b := func(c *ScenarioComparison) *ScenarioStats { return &c.B }
+	addAVO(avoA, a)
+	addAVO(avoB, b)
+	addSupplyDemand(sdA, a)
+	addSupplyDemand(sdB, b)
+
+	for i := range results {
+		c := &results[i]
+		c.Diff = ScenarioStats{
+			NumTaxis:      c.B.NumTaxis - c.A.NumTaxis,
+			NumPassengers: c.B.NumPassengers - c.A.NumPassengers,
+			PMT:           c.B.PMT - c.A.PMT,
+			VMT:           c.B.VMT - c.A.VMT,
+			AVO:           c.B.AVO - c.A.AVO,
+			Supply:        c.B.Supply - c.A.Supply,
+			Demand:        c.B.Demand - c.A.Demand,
+			Net:           c.B.Net - c.A.Net,
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return groupKeyLess(results[i].Key, results[j].Key) })
+	return results
+}
+
+// groupKeyLess orders group keys, county FIPS codes or hours, numerically.
+func groupKeyLess(a string, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// mergeSupplyDemand joins the demand and supply totals of the same groups.
+func mergeSupplyDemand(demand []GroupSupplyDemand, supply []GroupSupplyDemand) []GroupSupplyDemand {
+	index := make(map[string]int)
+	var results []GroupSupplyDemand
+	get := func(key string) *GroupSupplyDemand {
+		i, ok := index[key]
+		if !ok {
+			i = len(results)
+			index[key] = i
+			results = append(results, GroupSupplyDemand{Key: key})
+		}
+		return &results[i]
+	}
+	for _, d := range demand {
+		get(d.Key).Demand += d.Demand
+	}
+	for _, s := range supply {
+		get(s.Key).Supply += s.Supply
+	}
+	for i := range results {
+		results[i].Net = results[i].Supply - results[i].Demand
+	}
+	sort.Slice(results, func(i, j int) bool { return groupKeyLess(results[i].Key, results[j].Key) })
+	return results
+}
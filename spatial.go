@@ -0,0 +1,107 @@
+package ataxi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+)
+
+// ErrSpatialUnsupported is returned by the spatial queries of databases
+// without spatial support.
+var ErrSpatialUnsupported = errors.New("spatial queries are not supported by this database")
+
+// MaxSpatialRadius is the largest radius, in meters, taxis can be searched
+// within.
+const MaxSpatialRadius = 100000
+
+// Polygon is a region, such as a census tract, that demand can be aggregated
+// to.
+type Polygon struct {
+	Key      string
+	Name     string
+	Geometry string // GeoJSON Polygon or MultiPolygon, in WGS 84 lon/lat
+}
+
+// PolygonDemand is the number of taxis departing from a polygon, and the
+// passengers they carry.
+type PolygonDemand struct {
+	Key           string `gorm:"column:group_key"`
+	Name          string `gorm:"-"`
+	Demand        int    `gorm:"column:demand"`
+	NumPassengers int    `gorm:"column:num_passengers"`
+}
+
+// ReadPolygons reads the polygons of a GeoJSON feature collection, such as
+// the Census boundary files converted with ogr2ogr. A polygon's key is its
+// GEOID property, or else the feature id, and its name its NAME property.
+// Features that are not polygons are skipped.
+func ReadPolygons(path string) ([]Polygon, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var collection struct {
+		Features []struct {
+			ID         json.RawMessage            `json:"id"`
+			Properties map[string]json.RawMessage `json:"properties"`
+			Geometry   json.RawMessage            `json:"geometry"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(raw, &collection); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", path, err)
+	}
+	var polygons []Polygon
+	for i, feature := range collection.Features {
+		var geometry struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(feature.Geometry, &geometry); err != nil {
+			return nil, fmt.Errorf("could not parse geometry of feature %d of %s: %v", i, path, err)
+		}
+		if geometry.Type != "Polygon" && geometry.Type != "MultiPolygon" {
+			continue
+		}
+		polygon := Polygon{Geometry: string(feature.Geometry)}
+		if geoid, ok := feature.Properties["GEOID"]; ok {
+			polygon.Key = jsonText(geoid)
+		} else if len(feature.ID) > 0 && string(feature.ID) != "null" {
+			polygon.Key = jsonText(feature.ID)
+		} else {
+			polygon.Key = strconv.Itoa(i)
+		}
+		if name, ok := feature.Properties["NAME"]; ok {
+			polygon.Name = jsonText(name)
+		}
+		polygons = append(polygons, polygon)
+	}
+	return polygons, nil
+}
+
+// jsonText returns a json string unquoted, and other json values as written.
+func jsonText(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+// ValidatePolygon checks that geometry is a GeoJSON Polygon or MultiPolygon.
+func ValidatePolygon(geometry string) error {
+	var parsed struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	}
+	if err := json.Unmarshal([]byte(geometry), &parsed); err != nil {
+		return fmt.Errorf("polygon is not GeoJSON: %v", err)
+	}
+	if parsed.Type != "Polygon" && parsed.Type != "MultiPolygon" {
+		return fmt.Errorf("polygon must be a GeoJSON Polygon or MultiPolygon, got %q", parsed.Type)
+	}
+	if len(parsed.Coordinates) == 0 {
+		return errors.New("polygon has no coordinates")
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package ataxi
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Run records a run of region_avo or db_populate and the totals of the
+// counties it summarized.
+type Run struct {
+	ID            uint `gorm:"primary_key"`
+	CreatedAt     time.Time
+	Command       string `sql:"size:64"`
+	Scenario      string `sql:"size:64"`
+	Source        string `sql:"size:255"` // the files or directory read
+	NumCounties   int
+	NumTaxis      int
+	NumPassengers int
+	PMT           float64 `gorm:"column:pmt"`
+	VMT           float64 `gorm:"column:vmt"`
+	AVO           float64 `gorm:"-"`
+}
+
+func (Run) TableName() string { return "run" }
+
+// CountySummary is the fleet and miles traveled of a county in a run.
+type CountySummary struct {
+	ID            uint   `gorm:"primary_key"`
+	RunID         uint   `gorm:"index"`
+	FIPS          uint32 `gorm:"column:fips;index"`
+	StateFIPS     uint32 `gorm:"column:state_fips"`
+	Name          string `gorm:"-"`
+	Rows          int    // trip file rows read
+	NumTaxis      int
+	NumPassengers int
+	PMT           float64 `gorm:"column:pmt"`
+	VMT           float64 `gorm:"column:vmt"`
+	AVO           float64 `gorm:"-"`
+}
+
+func (CountySummary) TableName() string { return "county_summary" }
+
+// StateSummary is the fleet and miles traveled of the counties of a state in
+// a run.
+type StateSummary struct {
+	ID            uint   `gorm:"primary_key"`
+	RunID         uint   `gorm:"index"`
+	FIPS          uint32 `gorm:"column:fips;index"`
+	Name          string `gorm:"-"`
+	NumCounties   int
+	NumTaxis      int
+	NumPassengers int
+	PMT           float64 `gorm:"column:pmt"`
+	VMT           float64 `gorm:"column:vmt"`
+	AVO           float64 `gorm:"-"`
+}
+
+func (StateSummary) TableName() string { return "state_summary" }
+
+// maxSourceLength is the size of the source column of runs.
+const maxSourceLength = 255
+
+// AVO returns the average vehicle occupancy of the miles traveled, or 0
+// without any.
+func AVO(pmt float64, vmt float64) float64 {
+	if vmt > 0 {
+		return pmt / vmt
+	}
+	return 0
+}
+
+// fill computes the AVO of a run.
+func (run *Run) fill() {
+	run.AVO = AVO(run.PMT, run.VMT)
+}
+
+// fill computes the AVO of a county summary and names its county.
+func (summary *CountySummary) fill() {
+	summary.AVO = AVO(summary.PMT, summary.VMT)
+	county, _ := GetCounty(summary.FIPS)
+	summary.Name = county.Name
+}
+
+// fill computes the AVO of a state summary and names its state.
+func (summary *StateSummary) fill() {
+	summary.AVO = AVO(summary.PMT, summary.VMT)
+	state, _ := GetState(summary.FIPS)
+	summary.Name = state.Name
+}
+
+// SummarizeStates adds up county summaries by state, ordered by FIPS code.
+func SummarizeStates(counties []CountySummary) []StateSummary {
+	byFIPS := make(map[uint32]*StateSummary)
+	for _, county := range counties {
+		stateFIPS := StateFIPS(county.FIPS)
+		state, ok := byFIPS[stateFIPS]
+		if !ok {
+			state = &StateSummary{FIPS: stateFIPS}
+			byFIPS[stateFIPS] = state
+		}
+		state.NumCounties++
+		state.NumTaxis += county.NumTaxis
+		state.NumPassengers += county.NumPassengers
+		state.PMT += county.PMT
+		state.VMT += county.VMT
+	}
+	states := make([]StateSummary, 0, len(byFIPS))
+	for _, state := range byFIPS {
+		state.fill()
+		states = append(states, *state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].FIPS < states[j].FIPS })
+	return states
+}
+
+// SaveRun stores a run with the summaries of its counties and of their
+// states, in a single transaction. The run's totals are computed from the
+// counties.
+func SaveRun(db *gorm.DB, run *Run, counties []CountySummary) error {
+	run.NumCounties = len(counties)
+	run.NumTaxis, run.NumPassengers, run.PMT, run.VMT = 0, 0, 0, 0
+	for _, county := range counties {
+		run.NumTaxis += county.NumTaxis
+		run.NumPassengers += county.NumPassengers
+		run.PMT += county.PMT
+		run.VMT += county.VMT
+	}
+	run.fill()
+	if len(run.Source) > maxSourceLength {
+		run.Source = run.Source[:maxSourceLength]
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("could not save run: %v", tx.Error)
+	}
+	if err := tx.Create(run).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not save run: %v", err)
+	}
+	var rows []interface{}
+	for i := range counties {
+		counties[i].RunID = run.ID
+		counties[i].StateFIPS = StateFIPS(counties[i].FIPS)
+		rows = append(rows, &counties[i])
+	}
+	if err := insertRows(tx, rows); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not save county summaries: %v", err)
+	}
+	rows = nil
+	states := SummarizeStates(counties)
+	for i := range states {
+		states[i].RunID = run.ID
+		rows = append(rows, &states[i])
+	}
+	if err := insertRows(tx, rows); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not save state summaries: %v", err)
+	}
+	if err := BumpDatasetVersion(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("could not save run: %v", err)
+	}
+	return nil
+}
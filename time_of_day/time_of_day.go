@@ -83,13 +83,16 @@ func main() {
 			log.Fatal(err)
 		}
 
-		departureTime, _ := strconv.ParseInt(line[2], 10, 64)
+		departureTime, _ := strconv.ParseUint(line[2], 10, 32)
         vmt, _ := strconv.ParseFloat(line[6], 64)
         pmt, _ := strconv.ParseFloat(line[8], 64)
         numPassengers, _ := strconv.ParseInt(line[7], 10, 64)
 
-        category := ataxi.GetTimeCategory(int(departureTime))
-        hour := ataxi.GetHour(int(departureTime))
+        // Departure times are on the simulation clock and may fall on a later
+        // day, so bin them by their time of day.
+        departure := ataxi.SimTime(departureTime)
+        category := ataxi.GetTimeCategory(departure.TimeOfDay())
+        hour := departure.Hour()
 
         tripDistributionCategories[category] += int(numPassengers)
         tripDistributionHours[hour] += int(numPassengers)
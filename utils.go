@@ -72,7 +72,15 @@ func GetTripCategory(gcDist float64) uint32 {
 	return 4
 }
 
+// TimeCategoryBounds are the times of day, in seconds, at which the overnight,
+// morning peak, morning lull, early afternoon and evening rush categories of
+// GetTimeCategory end.
+var TimeCategoryBounds = []int{6 * 3600, 9 * 3600, 12 * 3600, 16 * 3600, 19 * 3600}
+
+// GetTimeCategory returns the time of day category for a simulation time in
+// seconds. Times past midnight fall into the category of their time of day.
 func GetTimeCategory(seconds int) int {
+    seconds = SimTime(seconds).TimeOfDay()
     if seconds < 6 * 3600 {
         return 0
     } else if seconds < 9 * 3600 {
@@ -88,7 +96,7 @@ func GetTimeCategory(seconds int) int {
 }
 
 func GetHour(seconds int) int {
-    return seconds / 3600
+    return SimTime(seconds).Hour()
 }
 
 func HashCode(x int32, y int32) int32 {